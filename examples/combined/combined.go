@@ -0,0 +1,27 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package combined is an example of a library tested by internal and
+// external tests built into one go_test.
+package combined
+
+// Double returns twice n.
+func Double(n int) int {
+	return double(n)
+}
+
+func double(n int) int {
+	return n * 2
+}
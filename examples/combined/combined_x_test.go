@@ -1,4 +1,4 @@
-/* Copyright 2016 The Bazel Authors. All rights reserved.
+/* Copyright 2017 The Bazel Authors. All rights reserved.
 
 Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
@@ -13,18 +13,16 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package combined_test
 
 import (
-	"io/ioutil"
+	"testing"
 
-	bzl "github.com/bazelbuild/buildtools/build"
-	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/examples/combined"
 )
 
-func fixFile(c *config.Config, file *bzl.File) error {
-	if err := ioutil.WriteFile(file.Path, bzl.Format(file), 0644); err != nil {
-		return err
+func TestDoubleForTest(t *testing.T) {
+	if got, want := combined.DoubleForTest(3), combined.Double(3); got != want {
+		t.Errorf("DoubleForTest(3) = %d; want %d", got, want)
 	}
-	return nil
 }
@@ -15,7 +15,7 @@
 load("@io_bazel_rules_go//go/private:common.bzl", "get_go_toolchain", "DEFAULT_LIB", "VENDOR_PREFIX", "go_filetype")
 load("@io_bazel_rules_go//go/private:asm.bzl", "emit_go_asm_action")
 
-def emit_library_actions(ctx, sources, deps, cgo_object, library):
+def emit_library_actions(ctx, sources, deps, cgo_object, library, importpath = None, archive_dir = ""):
   go_toolchain = get_go_toolchain(ctx)
 
   go_srcs = depset([s for s in sources if s.basename.endswith('.go')])
@@ -49,12 +49,17 @@ def emit_library_actions(ctx, sources, deps, cgo_object, library):
     emit_go_asm_action(ctx, src, asm_hdrs, obj)
     extra_objects += [obj]
 
-  importpath = go_importpath(ctx)
+  if not importpath:
+    importpath = go_importpath(ctx)
   lib_name = importpath + ".a"
-  out_lib = ctx.new_file(lib_name)
+  out_lib = ctx.new_file(archive_dir + lib_name)
   out_object = ctx.new_file(ctx.label.name + ".o")
   search_path = out_lib.path[:-len(lib_name)]
   gc_goopts = get_gc_goopts(ctx)
+  if archive_dir:
+    # The archive is not where a library with this import path would put it,
+    # so tell the compiler the package path explicitly.
+    gc_goopts = gc_goopts + ["-p", importpath]
   direct_go_library_paths = []
   transitive_go_library_deps = depset()
   transitive_go_library_paths = depset([search_path])
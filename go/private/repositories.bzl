@@ -81,6 +81,23 @@ def go_repositories(
       type = "zip",
   )
 
+  # Needed for gazelle
+  go_repository(
+      name = "com_github_fsnotify_fsnotify",
+      importpath = "github.com/fsnotify/fsnotify",
+      urls = ["https://codeload.github.com/fsnotify/fsnotify/zip/c2828203cd70a50dcccfb2761f8b1f8ceef9a8e9"],
+      strip_prefix = "fsnotify-c2828203cd70a50dcccfb2761f8b1f8ceef9a8e9",
+      type = "zip",
+  )
+
+  go_repository(
+      name = "org_golang_x_sys",
+      importpath = "golang.org/x/sys",
+      urls = ["https://codeload.github.com/golang/sys/zip/8dbc5d05d6edcc104950cc299a1ce6641235bc86"],
+      strip_prefix = "sys-8dbc5d05d6edcc104950cc299a1ce6641235bc86",
+      type = "zip",
+  )
+
   # Needed for fetch repo
   go_repository(
       name = "org_golang_x_tools",
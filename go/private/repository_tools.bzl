@@ -13,6 +13,7 @@
 # limitations under the License.
 
 load("@io_bazel_rules_go//go/private:go_repository.bzl", "go_repository", "env_execute")
+load("@io_bazel_rules_go//go/private:version.bzl", "RULES_GO_VERSION")
 
 _GO_REPOSITORY_TOOLS_BUILD_FILE = """
 package(default_visibility = ["//visibility:public"])
@@ -38,6 +39,19 @@ def _go_repository_tools_impl(ctx):
       type = "zip",
   )
 
+  # Gazelle watches files with fsnotify, which needs x/sys. These commits
+  # should also match the ones in repositories.bzl.
+  fsnotify_commit = "c2828203cd70a50dcccfb2761f8b1f8ceef9a8e9"
+  ctx.download_and_extract(
+      url = "https://codeload.github.com/fsnotify/fsnotify/zip/" + fsnotify_commit,
+      type = "zip",
+  )
+  x_sys_commit = "8dbc5d05d6edcc104950cc299a1ce6641235bc86"
+  ctx.download_and_extract(
+      url = "https://codeload.github.com/golang/sys/zip/" + x_sys_commit,
+      type = "zip",
+  )
+
   # We work this out here because you can't use a toolchain from a repository rule
   if ctx.os.name == 'linux':
     go_tool = ctx.path(Label("@go1_8_3_linux_amd64//:bin/go"))
@@ -47,11 +61,15 @@ def _go_repository_tools_impl(ctx):
     fail("Unsupported operating system: " + ctx.os.name)
 
   x_tools_path = ctx.path('tools-' + x_tools_commit)
+  fsnotify_path = ctx.path('fsnotify-' + fsnotify_commit)
+  x_sys_path = ctx.path('sys-' + x_sys_commit)
   buildtools_path = ctx.path(ctx.attr._buildtools).dirname
   go_tools_path = ctx.path(ctx.attr._tools).dirname
 
   # Build something that looks like a normal GOPATH so go install will work
   ctx.symlink(x_tools_path, "src/golang.org/x/tools")
+  ctx.symlink(fsnotify_path, "src/github.com/fsnotify/fsnotify")
+  ctx.symlink(x_sys_path, "src/golang.org/x/sys")
   ctx.symlink(buildtools_path, "src/github.com/bazelbuild/buildtools")
   ctx.symlink(go_tools_path, "src/github.com/bazelbuild/rules_go/go/tools")
   env = {
@@ -60,7 +78,8 @@ def _go_repository_tools_impl(ctx):
   }
 
   # build gazelle and fetch_repo
-  result = env_execute(ctx, [go_tool, "install", 'github.com/bazelbuild/rules_go/go/tools/gazelle/gazelle'], environment = env)
+  ldflags = "-X github.com/bazelbuild/rules_go/go/tools/gazelle/runner.RulesGoVersion=" + RULES_GO_VERSION
+  result = env_execute(ctx, [go_tool, "install", "-ldflags", ldflags, 'github.com/bazelbuild/rules_go/go/tools/gazelle/gazelle'], environment = env)
   if result.return_code:
       fail("failed to build gazelle: %s" % result.stderr)
   result = env_execute(ctx, [go_tool, "install", 'github.com/bazelbuild/rules_go/go/tools/fetch_repo'], environment = env)
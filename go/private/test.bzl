@@ -23,20 +23,59 @@ def _go_test_impl(ctx):
   test into a binary."""
 
   go_toolchain = get_go_toolchain(ctx)
+  go_import = go_importpath(ctx)
+  archive_dir = ""
+  if ctx.files.xtest_srcs and not ctx.attr.library and not ctx.attr.importpath:
+    fail("xtest_srcs requires library or importpath")
+  if ctx.files.xtest_srcs and ctx.attr.library and not ctx.attr.importpath:
+    # External tests import the package under test by the library's import
+    # path, so the internal test package must be compiled with it, too. The
+    # library already declares an archive at that path, so the test's
+    # archives go in a directory of their own.
+    go_import = ctx.attr.library.importpath
+    archive_dir = ctx.label.name + "~internal/"
+    _check_no_library_dependents(ctx)
   lib_result = emit_library_actions(ctx,
       sources = depset(ctx.files.srcs),
       deps = ctx.attr.deps,
       cgo_object = None,
       library = ctx.attr.library,
+      importpath = go_import,
+      archive_dir = archive_dir,
   )
   main_go = ctx.new_file(ctx.label.name + "_main_test.go")
   main_object = ctx.new_file(ctx.label.name + "_main_test.o")
   main_lib = ctx.new_file(ctx.label.name + "_main_test.a")
-  go_import = go_importpath(ctx)
   run_dir = pkg_dir(ctx.label.workspace_root, ctx.label.package)
 
+  test_sources = list(lib_result.go_sources)
+  test_libs = lib_result.transitive_go_libraries
+  test_imports = [go_import]
+  generator_args = []
+  if ctx.files.xtest_srcs:
+    # External test sources (package foo_test) are compiled into a separate
+    # package which may import the internal test package, including helpers
+    # defined in internal test files, as with "go test".
+    xtest_import = go_import + "_test"
+    xtest_lib = ctx.new_file(archive_dir + xtest_import + ".a")
+    xtest_object = ctx.new_file(ctx.label.name + "_xtest.o")
+    xtest_sources = emit_go_compile_action(
+      ctx,
+      sources=depset(ctx.files.xtest_srcs),
+      libs=lib_result.transitive_go_libraries,
+      lib_paths=lib_result.transitive_go_library_paths,
+      direct_paths=[go_import] + [dep.importpath for dep in ctx.attr.deps],
+      out_object=xtest_object,
+      gc_goopts=get_gc_goopts(ctx),
+    )
+    emit_go_pack_action(ctx, xtest_lib, [xtest_object])
+    test_sources += list(xtest_sources)
+    test_libs += [xtest_lib]
+    test_imports += [xtest_import]
+    generator_args += ['--xpackage', xtest_import]
+
   ctx.action(
-      inputs = list(lib_result.go_sources),
+      inputs = test_sources,
       outputs = [main_go],
       mnemonic = "GoTestGenTest",
       executable = go_toolchain.test_generator,
@@ -47,16 +86,16 @@ def _go_test_impl(ctx):
           run_dir,
           '--output',
           main_go.path,
-      ] + [src.path for src in lib_result.go_sources],
+      ] + generator_args + [src.path for src in test_sources],
       env = dict(go_toolchain.env, RUNDIR=ctx.label.package)
   )
 
   emit_go_compile_action(
     ctx,
     sources=depset([main_go]),
-    libs=lib_result.transitive_go_libraries,
+    libs=test_libs,
     lib_paths=lib_result.transitive_go_library_paths,
-    direct_paths=[go_import],
+    direct_paths=test_imports,
     out_object=main_object,
     gc_goopts=get_gc_goopts(ctx),
   )
@@ -64,7 +103,7 @@ def _go_test_impl(ctx):
   emit_go_link_action(
     ctx,
     transitive_go_library_paths=lib_result.transitive_go_library_paths,
-    transitive_go_libraries=lib_result.transitive_go_libraries,
+    transitive_go_libraries=test_libs,
     cgo_deps=lib_result.transitive_cgo_deps,
     libs=[main_lib],
     executable=ctx.outputs.executable,
@@ -81,6 +120,21 @@ def _go_test_impl(ctx):
       runfiles = runfiles,
   )
 
+def _check_no_library_dependents(ctx):
+  """Fails if a dependency of the test depends on the library under test.
+
+  "go test" recompiles packages that import the package under test against
+  the internal test package. Bazel can't do that, so those packages would be
+  linked with two archives for the same import path."""
+  library_archives = [f.path for f in ctx.attr.library.files]
+  for dep in ctx.attr.deps:
+    for lib in dep.transitive_go_libraries:
+      if lib.path in library_archives:
+        fail(("%s depends on the library under test, %s, so it can't be " +
+              "linked with the internal test sources; put the external " +
+              "tests in a separate go_test instead of xtest_srcs") %
+             (dep.label, ctx.attr.library.label), "deps")
+
 go_test = rule(
     _go_test_impl,
     attrs = {
@@ -89,6 +143,7 @@ go_test = rule(
             cfg = "data",
         ),
         "srcs": attr.label_list(allow_files = go_filetype),
+        "xtest_srcs": attr.label_list(allow_files = go_filetype),
         "deps": attr.label_list(
             providers = [
                 "transitive_go_library_paths",
@@ -55,15 +55,43 @@ type coverInfo struct {
 // Cases holds template data.
 type Cases struct {
 	Package          string
+	XPackage         string
 	RunDir           string
-	TestNames        []string
-	BenchmarkNames   []string
-	HasTestMain      bool
+	Tests            []TestCase
+	Benchmarks       []TestCase
+	TestMain         string
 	Version17        bool
 	Version18OrNewer bool
 	Cover            []coverInfo
 }
 
+// TestCase is a test or benchmark function. Package is the name the
+// generated file uses to import the package containing the function:
+// "undertest" for the package being tested (including internal test files),
+// or "xundertest" for the external test package.
+type TestCase struct {
+	Package, Name string
+}
+
+// Imports returns whether the generated file refers to functions in the
+// package imported with the given name.
+func (c *Cases) Imports(name string) bool {
+	if c.TestMain == name {
+		return true
+	}
+	for _, tc := range c.Tests {
+		if tc.Package == name {
+			return true
+		}
+	}
+	for _, tc := range c.Benchmarks {
+		if tc.Package == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Cases) CoverMode() string {
 	if testCoverMode == "" {
 		return "set"
@@ -100,11 +128,12 @@ import (
 	"testing/internal/testdeps"
 {{end}}
 
-{{if .TestNames}}
-	undertest "{{.Package}}"
-{{else if .BenchmarkNames}}
+{{if .Imports "undertest"}}
 	undertest "{{.Package}}"
 {{end}}
+{{if .XPackage}}
+	{{if .Imports "xundertest"}}xundertest{{else}}_{{end}} "{{.XPackage}}"
+{{end}}
 
 {{if .CoverEnabled}}
 	{{$pkg := .Package}}
@@ -115,14 +144,14 @@ import (
 )
 
 var tests = []testing.InternalTest{
-{{range .TestNames}}
-	{"{{.}}", undertest.{{.}} },
+{{range .Tests}}
+	{"{{.Name}}", {{.Package}}.{{.Name}} },
 {{end}}
 }
 
 var benchmarks = []testing.InternalBenchmark{
-{{range .BenchmarkNames}}
-	{"{{.}}", undertest.{{.}} },
+{{range .Benchmarks}}
+	{"{{.Name}}", {{.Package}}.{{.Name}} },
 {{end}}
 }
 
@@ -187,17 +216,17 @@ func main() {
 
 {{if .Version18OrNewer}}
 	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, nil)
-	{{if not .HasTestMain}}
+	{{if not .TestMain}}
 	os.Exit(m.Run())
 	{{else}}
-	undertest.TestMain(m)
+	{{.TestMain}}.TestMain(m)
 	{{end}}
 {{else if .Version17}}
-	{{if not .HasTestMain}}
+	{{if not .TestMain}}
 	testing.Main(regexp.MatchString, tests, benchmarks, nil)
 	{{else}}
 	m := testing.MainStart(regexp.MatchString, tests, benchmarks, nil)
-	undertest.TestMain(m)
+	{{.TestMain}}.TestMain(m)
 	{{end}}
 {{end}}
 }
@@ -207,6 +236,7 @@ func run(args []string) error {
 	// Prepare our flags
 	flags := flag.NewFlagSet("generate_test_main", flag.ExitOnError)
 	pkg := flags.String("package", "", "package from which to import test methods.")
+	xpkg := flags.String("xpackage", "", "external test package from which to import test methods. If set, files\n\tin packages with names ending in _test belong to this package.")
 	runDir := flags.String("rundir", ".", "Path to directory where tests should run.")
 	out := flags.String("output", "", "output file to write. Defaults to stdout.")
 	tags := flags.String("tags", "", "Only pass through files that match these tags.")
@@ -239,9 +269,10 @@ func run(args []string) error {
 		Vars: map[string]*CoverVar{},
 	}
 	cases := Cases{
-		Package: *pkg,
-		RunDir:  *runDir,
-		Cover:   []coverInfo{ci},
+		Package:  *pkg,
+		XPackage: *xpkg,
+		RunDir:   *runDir,
+		Cover:    []coverInfo{ci},
 	}
 	testFileSet := token.NewFileSet()
 	for _, f := range filenames {
@@ -257,6 +288,10 @@ func run(args []string) error {
 		if err != nil {
 			return fmt.Errorf("ParseFile(%q): %v", f, err)
 		}
+		pkgName := "undertest"
+		if *xpkg != "" && strings.HasSuffix(parse.Name.Name, "_test") {
+			pkgName = "xundertest"
+		}
 
 		for _, d := range parse.Decls {
 			fn, ok := d.(*ast.FuncDecl)
@@ -268,7 +303,7 @@ func run(args []string) error {
 			}
 			if fn.Name.Name == "TestMain" {
 				// TestMain is not, itself, a test
-				cases.HasTestMain = true
+				cases.TestMain = pkgName
 				continue
 			}
 
@@ -305,13 +340,13 @@ func run(args []string) error {
 				if selExpr.Sel.Name != "T" {
 					continue
 				}
-				cases.TestNames = append(cases.TestNames, fn.Name.Name)
+				cases.Tests = append(cases.Tests, TestCase{Package: pkgName, Name: fn.Name.Name})
 			}
 			if strings.HasPrefix(fn.Name.Name, "Benchmark") {
 				if selExpr.Sel.Name != "B" {
 					continue
 				}
-				cases.BenchmarkNames = append(cases.BenchmarkNames, fn.Name.Name)
+				cases.Benchmarks = append(cases.Benchmarks, TestCase{Package: pkgName, Name: fn.Name.Name})
 			}
 		}
 	}
@@ -17,6 +17,8 @@ package config
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 )
 
 // Config holds information about how Gazelle should run. This is mostly
@@ -41,12 +43,125 @@ type Config struct {
 	// should include GenericTags. It should not be nil.
 	Platforms PlatformTags
 
+	// TestTags is a set of build constraints that are checked in addition to
+	// GenericTags and Platforms for test sources that aren't built without
+	// them. Languages set it from directives, so that tests which only build
+	// with certain tags (for example, integration tests) can be generated and
+	// tagged "manual".
+	TestTags BuildTags
+
 	// GoPrefix is the portion of the import path for the root of this repository.
 	// This is used to map imports to labels within the repository.
 	GoPrefix string
 
 	// DepMode determines how imports outside of GoPrefix are resolved.
 	DepMode DependencyMode
+
+	// DepModeOverrides maps import path prefixes to dependency modes that
+	// should be used instead of DepMode for imports with those prefixes.
+	// The longest matching prefix is used. See DepModeFor.
+	DepModeOverrides map[string]DependencyMode
+
+	// WalkNestedRepos determines whether Gazelle descends into directories
+	// that contain their own WORKSPACE or go.mod file. By default, these
+	// directories are treated as separate repositories: no rules are
+	// generated in them, and imports of packages inside them are resolved
+	// using NestedRepos.
+	WalkNestedRepos bool
+
+	// NestedRepos is a list of repositories nested inside RepoRoot. It is
+	// populated before rules are generated unless WalkNestedRepos is set.
+	NestedRepos []NestedRepo
+
+	// ImportComments maps import paths declared in import comments to the
+	// slash-separated directories, relative to RepoRoot, of the packages
+	// that declare them. Only paths that differ from the paths derived from
+	// GoPrefix are included. It is populated before rules are generated
+	// unless NonRecursive is set.
+	ImportComments map[string]string
+
+	// NonRecursive determines whether Gazelle only generates rules in Dirs
+	// themselves rather than in Dirs and their subdirectories. Build files
+	// in parent directories are still read for configuration, but the rest
+	// of the repository is not searched for nested repositories or import
+	// comments. This is useful for quickly updating one directory, for
+	// example, when a file is saved in an editor.
+	NonRecursive bool
+
+	// Excludes is a list of patterns for files and directories that Gazelle
+	// should skip entirely. Patterns are matched against slash-separated paths
+	// relative to RepoRoot using path.Match. A pattern without a slash also
+	// matches files and directories with that base name anywhere in the tree.
+	Excludes []string
+
+	// FollowSymlinks determines whether Gazelle follows symbolic links to
+	// directories inside RepoRoot. Links to directories outside RepoRoot are
+	// never followed.
+	FollowSymlinks bool
+
+	// ThreeWayMerge determines whether existing build files are merged with
+	// generated files using the content Gazelle generated the last time it
+	// was run as a base. That content is read from record files under
+	// RecordDir (see RecordPath). When there is no record, generated
+	// files are merged with existing files directly.
+	ThreeWayMerge bool
+
+	// WriteRecords determines whether Gazelle writes the content it generates
+	// to record files after build files are emitted. It should only be set
+	// when emitted files are written, so the records match what was written.
+	WriteRecords bool
+
+	// KindMap maps the kinds of rules Gazelle generates to wrapper kinds
+	// that should be generated and matched instead, as configured with
+	// "# gazelle:map_kind" directives. It is keyed by FromKind.
+	KindMap map[string]MappedKind
+
+	// Exts holds configuration for language extensions, keyed by language
+	// name. Values should be treated as immutable; a language that changes
+	// its configuration for a directory should store a new value.
+	Exts map[string]interface{}
+}
+
+// MappedKind describes a wrapper kind, usually a macro, that forwards to a
+// rule kind Gazelle generates.
+type MappedKind struct {
+	// FromKind is the kind Gazelle generates, for example, "go_library".
+	FromKind string
+
+	// KindName is the wrapper kind, for example, "my_go_library".
+	KindName string
+
+	// KindLoad is the label of the .bzl file that defines KindName, for
+	// example, "//tools:go.bzl".
+	KindLoad string
+}
+
+// NestedRepo describes a repository whose root directory is inside RepoRoot.
+type NestedRepo struct {
+	// Rel is the slash-separated path from RepoRoot to the root directory
+	// of the nested repository.
+	Rel string
+
+	// Name is the workspace name of the nested repository, as declared in
+	// its WORKSPACE file. It may be empty, in which case a name is derived
+	// from GoPrefix.
+	Name string
+
+	// GoPrefix is the portion of the import path for the root of the nested
+	// repository.
+	GoPrefix string
+}
+
+// Clone returns a copy of the configuration. The copy may be modified
+// without affecting the original, except for values stored in Exts and
+// the contents of slices and maps other than Exts.
+func (c *Config) Clone() *Config {
+	cc := *c
+	cc.Exts = make(map[string]interface{}, len(c.Exts))
+	for k, v := range c.Exts {
+		cc.Exts[k] = v
+	}
+	return &cc
 }
 
 var DefaultValidBuildFileNames = []string{"BUILD.bazel", "BUILD"}
@@ -64,6 +179,27 @@ func (c *Config) DefaultBuildFileName() string {
 	return c.ValidBuildFileNames[0]
 }
 
+// RecordDir is the directory, relative to the repository root, where
+// Gazelle keeps records of the build files it generated. The directory
+// mirrors the layout of the repository.
+const RecordDir = ".gazelle"
+
+// RecordPath returns the path of the file where Gazelle records the
+// content it generated for the build file at "buildPath". Records of all
+// build files are kept under RecordDir, so they can be committed or ignored
+// together. Records have a ".gazelle" suffix, so Bazel does not treat
+// them as build files.
+func (c *Config) RecordPath(buildPath string) string {
+	rel, err := filepath.Rel(c.RepoRoot, buildPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// Build files outside the repository don't have records in it. Keep
+		// the record next to the build file.
+		dir, base := filepath.Split(buildPath)
+		return filepath.Join(dir, "."+base+".gazelle")
+	}
+	return filepath.Join(c.RepoRoot, RecordDir, rel+".gazelle")
+}
+
 // BuildTags is a set of build constraints.
 type BuildTags map[string]bool
 
@@ -108,20 +244,46 @@ const (
 	ExternalMode DependencyMode = iota
 
 	// VendorMode indicates imports should be resolved to libraries in the
-	// vendor directory.
+	// nearest vendor directory containing them, or in the root vendor
+	// directory if they aren't vendored anywhere.
 	VendorMode
+
+	// HybridMode indicates imports should be resolved to libraries in the
+	// vendor directory when they are vendored and to external dependencies
+	// otherwise.
+	HybridMode
 )
 
 // DependencyModeFromString converts a string from the command line
-// to a DependencyMode. Valid strings are "external", "vendor". An error will
-// be returned for an invalid string.
+// to a DependencyMode. Valid strings are "external", "vendored", and
+// "hybrid". An error will be returned for an invalid string.
 func DependencyModeFromString(s string) (DependencyMode, error) {
 	switch s {
 	case "external":
 		return ExternalMode, nil
 	case "vendored":
 		return VendorMode, nil
+	case "hybrid":
+		return HybridMode, nil
 	default:
 		return 0, fmt.Errorf("unrecognized dependency mode: %q", s)
 	}
 }
+
+// DepModeFor returns the dependency mode that should be used to resolve
+// "importpath". This is the mode in DepModeOverrides with the longest prefix
+// matching importpath, or DepMode if there is no match.
+func (c *Config) DepModeFor(importpath string) DependencyMode {
+	mode := c.DepMode
+	best := -1
+	for prefix, m := range c.DepModeOverrides {
+		if importpath != prefix && !strings.HasPrefix(importpath, prefix+"/") {
+			continue
+		}
+		if len(prefix) > best {
+			mode = m
+			best = len(prefix)
+		}
+	}
+	return mode
+}
@@ -46,3 +46,28 @@ func TestPreprocessTags(t *testing.T) {
 		}
 	}
 }
+
+func TestDepModeFor(t *testing.T) {
+	c := &Config{
+		DepMode: HybridMode,
+		DepModeOverrides: map[string]DependencyMode{
+			"example.com/a":   ExternalMode,
+			"example.com/a/b": VendorMode,
+		},
+	}
+	for _, spec := range []struct {
+		importpath string
+		want       DependencyMode
+	}{
+		{"example.com/a", ExternalMode},
+		{"example.com/a/x", ExternalMode},
+		{"example.com/a/b", VendorMode},
+		{"example.com/a/b/c", VendorMode},
+		{"example.com/ab", HybridMode},
+		{"example.com/c", HybridMode},
+	} {
+		if got := c.DepModeFor(spec.importpath); got != spec.want {
+			t.Errorf("DepModeFor(%q) = %v; want %v", spec.importpath, got, spec.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// directivePrefix is the prefix of comments in build files that configure
+// Gazelle.
+const directivePrefix = "# gazelle:"
+
+// Directive is a key-value pair extracted from a comment in a build file of
+// the form "# gazelle:key value". Directives configure Gazelle for the
+// directory containing the build file and its subdirectories.
+type Directive struct {
+	Key, Value string
+}
+
+// ParseDirectives returns the directives in comments before or after any
+// top-level statement in "f", in the order they appear. f may be nil.
+func ParseDirectives(f *bzl.File) []Directive {
+	if f == nil {
+		return nil
+	}
+	var directives []Directive
+	parse := func(comments []bzl.Comment) {
+		for _, c := range comments {
+			if !strings.HasPrefix(c.Token, directivePrefix) {
+				continue
+			}
+			text := strings.TrimSpace(strings.TrimPrefix(c.Token, directivePrefix))
+			d := Directive{Key: text}
+			if i := strings.IndexAny(text, " \t"); i >= 0 {
+				d.Key = text[:i]
+				d.Value = strings.TrimSpace(text[i+1:])
+			}
+			directives = append(directives, d)
+		}
+	}
+	for _, s := range f.Stmt {
+		parse(s.Comment().Before)
+		parse(s.Comment().After)
+	}
+	return directives
+}
+
+// ParseMapKind parses the value of a "map_kind" directive, which has the
+// form "from_kind kind_name kind_load".
+func ParseMapKind(value string) (MappedKind, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return MappedKind{}, fmt.Errorf("map_kind %q: want from_kind kind_name kind_load", value)
+	}
+	return MappedKind{FromKind: fields[0], KindName: fields[1], KindLoad: fields[2]}, nil
+}
+
+// ApplyKindMap sets c.KindMap from the "map_kind" directives in "f",
+// adding to the mappings inherited from parent directories. Invalid
+// directives are logged and ignored.
+func ApplyKindMap(c *Config, f *bzl.File) {
+	for _, d := range ParseDirectives(f) {
+		if d.Key != "map_kind" {
+			continue
+		}
+		mk, err := ParseMapKind(d.Value)
+		if err != nil {
+			log.Printf("%s: %v", f.Path, err)
+			continue
+		}
+		// c is a copy of the parent configuration, which shares KindMap.
+		kinds := make(map[string]MappedKind, len(c.KindMap)+1)
+		for k, v := range c.KindMap {
+			kinds[k] = v
+		}
+		kinds[mk.FromKind] = mk
+		c.KindMap = kinds
+	}
+}
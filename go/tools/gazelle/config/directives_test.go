@@ -0,0 +1,71 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+func TestParseDirectives(t *testing.T) {
+	f, err := bzl.Parse("BUILD", []byte(`# gazelle:ignore
+# gazelle:default_visibility //foo:__pkg__, //bar:__subpackages__
+
+# Not a directive.
+go_library(
+    name = "go_default_library",
+)
+# gazelle:build_file_name  BUILD.bazel
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ParseDirectives(f)
+	want := []Directive{
+		{Key: "ignore"},
+		{Key: "default_visibility", Value: "//foo:__pkg__, //bar:__subpackages__"},
+		{Key: "build_file_name", Value: "BUILD.bazel"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+func TestApplyKindMap(t *testing.T) {
+	f, err := bzl.Parse("BUILD", []byte(`# gazelle:map_kind go_library my_go_library //tools:go.bzl
+# gazelle:map_kind go_test
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := map[string]MappedKind{
+		"go_binary": {FromKind: "go_binary", KindName: "my_go_binary", KindLoad: "//tools:go.bzl"},
+	}
+	c := &Config{KindMap: parent}
+	ApplyKindMap(c, f)
+	want := map[string]MappedKind{
+		"go_binary":  {FromKind: "go_binary", KindName: "my_go_binary", KindLoad: "//tools:go.bzl"},
+		"go_library": {FromKind: "go_library", KindName: "my_go_library", KindLoad: "//tools:go.bzl"},
+	}
+	if !reflect.DeepEqual(c.KindMap, want) {
+		t.Errorf("got %#v; want %#v", c.KindMap, want)
+	}
+	if len(parent) != 1 {
+		t.Errorf("parent configuration was modified: %#v", parent)
+	}
+}
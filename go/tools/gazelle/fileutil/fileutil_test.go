@@ -0,0 +1,115 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "WORKSPACE")
+	if err := ioutil.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		data    string
+		written bool
+	}{
+		{"new", true},
+		{"new", false},
+	} {
+		written, err := WriteFile(path, []byte(tc.data))
+		if err != nil {
+			t.Fatalf("WriteFile failed with %v; want success", err)
+		}
+		if written != tc.written {
+			t.Errorf("WriteFile returned %v; want %v", written, tc.written)
+		}
+		if got, err := ioutil.ReadFile(path); err != nil {
+			t.Fatal(err)
+		} else if string(got) != tc.data {
+			t.Errorf("got %q; want %q", got, tc.data)
+		}
+	}
+
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v; want %v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(files) != 1 {
+		t.Errorf("got %d files; want only %s", len(files), path)
+	}
+}
+
+func TestWriteFileSymlink(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	targetDir := filepath.Join(dir, "target")
+	linkDir := filepath.Join(dir, "link")
+	for _, d := range []string{targetDir, linkDir} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target := filepath.Join(targetDir, "BUILD.bazel")
+	if err := ioutil.WriteFile(target, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(linkDir, "BUILD.bazel")
+	if err := os.Symlink(filepath.Join("..", "target", "BUILD.bazel"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := WriteFile(link, []byte("new")); err != nil {
+		t.Fatalf("WriteFile failed with %v; want success", err)
+	}
+	if fi, err := os.Lstat(link); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s was replaced with a regular file; want a link", link)
+	}
+	if got, err := ioutil.ReadFile(target); err != nil {
+		t.Fatal(err)
+	} else if string(got) != "new" {
+		t.Errorf("got %q; want %q", got, "new")
+	}
+	for _, d := range []string{targetDir, linkDir} {
+		if files, err := ioutil.ReadDir(d); err != nil {
+			t.Fatal(err)
+		} else if len(files) != 1 {
+			t.Errorf("got %d files in %s; want 1", len(files), d)
+		}
+	}
+}
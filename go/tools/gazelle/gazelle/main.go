@@ -18,95 +18,39 @@ limitations under the License.
 package main
 
 import (
-	"errors"
+	"bufio"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 
-	bzl "github.com/bazelbuild/buildtools/build"
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
-	"github.com/bazelbuild/rules_go/go/tools/gazelle/merger"
-	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
-	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
-	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/graph"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/runner"
 )
 
-type emitFunc func(*config.Config, *bzl.File) error
+// multiFlag allows repeated string flags to be collected into a slice.
+type multiFlag []string
 
-var modeFromName = map[string]emitFunc{
-	"print": printFile,
-	"fix":   fixFile,
-	"diff":  diffFile,
-}
-
-func run(c *config.Config, emit emitFunc) {
-	g := rules.NewGenerator(c)
-	shouldProcessRoot := false
-	didProcessRoot := false
-	for _, dir := range c.Dirs {
-		if c.RepoRoot == dir {
-			shouldProcessRoot = true
-		}
-		packages.Walk(c, dir, func(pkg *packages.Package, oldFile *bzl.File) {
-			if pkg.Rel == "" {
-				didProcessRoot = true
-			}
-			processPackage(c, g, emit, pkg, oldFile)
-		})
-	}
-	if shouldProcessRoot && !didProcessRoot {
-		// We did not process a package at the repository root. We need to put
-		// a go_prefix rule there, even if there are no .go files in that directory.
-		pkg := &packages.Package{Dir: c.RepoRoot}
-		var oldFile *bzl.File
-		var oldData []byte
-		oldPath, err := findBuildFile(c, c.RepoRoot)
-		if os.IsNotExist(err) {
-			goto processRoot
-		}
-		if err != nil {
-			log.Print(err)
-			return
-		}
-		oldData, err = ioutil.ReadFile(oldPath)
-		if err != nil {
-			log.Print(err)
-			return
-		}
-		oldFile, err = bzl.Parse(oldPath, oldData)
-		if err != nil {
-			log.Print(err)
-			return
-		}
-
-	processRoot:
-		processPackage(c, g, emit, pkg, oldFile)
+func (m *multiFlag) String() string {
+	if m == nil {
+		return ""
 	}
+	return strings.Join(*m, ",")
 }
 
-func processPackage(c *config.Config, g rules.Generator, emit emitFunc, pkg *packages.Package, oldFile *bzl.File) {
-	genFile := g.Generate(pkg)
-
-	if oldFile == nil {
-		// No existing file, so no merge required.
-		bzl.Rewrite(genFile, nil) // have buildifier 'format' our rules.
-		if err := emit(c, genFile); err != nil {
-			log.Print(err)
-		}
-		return
-	}
+func (m *multiFlag) Set(v string) error {
+	*m = append(*m, v)
+	return nil
+}
 
-	// Existing file, so merge and replace the old one.
-	mergedFile := merger.MergeWithExisting(genFile, oldFile)
-	bzl.Rewrite(mergedFile, nil) // have buildifier 'format' our rules.
-	if err := emit(c, mergedFile); err != nil {
-		log.Print(err)
-		return
-	}
+var modeFromName = map[string]runner.EmitFunc{
+	"print": runner.PrintFile,
+	"fix":   runner.FixFileAndReport(reportChangedFile),
+	"diff":  runner.DiffFile,
 }
 
 func usage(fs *flag.FlagSet) {
@@ -120,15 +64,24 @@ You can still use Gazelle for other purposes, but its interface can change witho
 notice.
 
 It takes a list of paths to Go package directories [defaults to . if none given].
-It recursively traverses its subpackages.
+It recursively traverses its subpackages unless -r=false is given, in which
+case only the listed directories are updated.
 All the directories must be under the directory specified in -repo_root.
 [if -repo_root is not given, gazelle searches $pwd and up for the WORKSPACE file]
+If -go_prefix is not given and the root BUILD file has no go_prefix rule, the
+prefix is inferred from import comments, GOPATH, or a git remote. When run in
+fix mode from a terminal, gazelle offers to create a missing WORKSPACE file and
+to record the inferred prefix in the root BUILD file.
 
 There are several modes of gazelle.
 In print mode, gazelle prints reconciled BUILD files to stdout.
-In fix mode, gazelle creates BUILD files or updates existing ones.
+In fix mode, gazelle creates BUILD files or updates existing ones. Files are
+only written if their content changes, and the files written are listed.
 In diff mode, gazelle shows diff.
 
+With -watch, gazelle keeps running after it fixes BUILD files and updates them
+again whenever source files change. Press Ctrl-C to stop.
+
 FLAGS:
 `)
 	fs.PrintDefaults()
@@ -138,15 +91,93 @@ func main() {
 	log.SetPrefix("gazelle: ")
 	log.SetFlags(0) // don't print timestamps
 
-	c, emit, err := newConfiguration(os.Args[1:])
+	c, opts, err := newConfiguration(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	run(c, emit)
+	if opts.watch {
+		if err := watch(c, opts.emit); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if opts.depGraph == nil {
+		runner.Run(c, opts.emit)
+		return
+	}
+
+	runner.RunWithDeps(c, opts.emit, opts.depGraph)
+	if errs := runner.CheckDeps(opts.depGraph, opts.layerRules); len(errs) > 0 {
+		for _, err := range errs {
+			log.Print(err)
+		}
+		os.Exit(1)
+	}
+}
+
+// watch runs Gazelle in watch mode until it is interrupted.
+func watch(c *config.Config, emit runner.EmitFunc) error {
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		close(stop)
+	}()
+	return runner.Watch(c, emit, stop)
 }
 
-func newConfiguration(args []string) (*config.Config, emitFunc, error) {
+// reportChangedFile logs the path of a file written in fix mode.
+func reportChangedFile(c *config.Config, path string) {
+	if rel, err := filepath.Rel(c.RepoRoot, path); err == nil {
+		path = rel
+	}
+	log.Printf("updated %s", path)
+}
+
+// isInteractive returns whether Gazelle's standard input and standard error
+// are connected to a terminal.
+func isInteractive() bool {
+	for _, f := range []*os.File{os.Stdin, os.Stderr} {
+		fi, err := f.Stat()
+		if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// confirm asks a yes or no question on standard error and returns whether
+// the answer read from standard input was yes.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// options holds command line settings that control how Gazelle runs but
+// aren't part of the configuration passed to each directory.
+type options struct {
+	// emit is called for each generated build file.
+	emit runner.EmitFunc
+
+	// watch is true if Gazelle should run in watch mode.
+	watch bool
+
+	// depGraph records dependencies between targets in the repository. It is
+	// nil unless -check_deps or -forbid_deps was given.
+	depGraph *graph.Graph
+
+	// layerRules lists dependencies that are not allowed between targets.
+	layerRules []graph.LayerRule
+}
+
+// newConfiguration parses the command line. It returns the configuration
+// and the options that control how Gazelle runs.
+func newConfiguration(args []string) (*config.Config, *options, error) {
 	fs := flag.NewFlagSet("gazelle", flag.ContinueOnError)
 	// Flag will call this on any parse error. Don't print usage unless
 	// -h or -help were passed explicitly.
@@ -154,9 +185,21 @@ func newConfiguration(args []string) (*config.Config, emitFunc, error) {
 
 	buildFileName := fs.String("build_file_name", "BUILD.bazel,BUILD", "comma-separated list of valid build file names.\nThe first element of the list is the name of output build files to generate.")
 	buildTags := fs.String("build_tags", "", "comma-separated list of build tags. If not specified, Gazelle will not\n\tfilter sources with build constraints.")
-	external := fs.String("external", "external", "external: resolve external packages with go_repository\n\tvendored: resolve external packages as packages in vendor/")
+	external := fs.String("external", "external", "external: resolve external packages with go_repository\n\tvendored: resolve external packages as packages in vendor/, even if they\n\taren't vendored\n\thybrid: resolve external packages in vendor/ if they are vendored, otherwise with go_repository")
+	var externalOverrides multiFlag
+	fs.Var(&externalOverrides, "external_override", "importpath=mode: resolve packages with the given import path prefix using\n\tthe given mode instead of the -external mode. May be repeated.")
 	goPrefix := fs.String("go_prefix", "", "go_prefix of the target workspace")
 	repoRoot := fs.String("repo_root", "", "path to a directory which corresponds to go_prefix, otherwise gazelle searches for it.")
+	recursive := fs.Bool("r", true, "if true, Gazelle generates rules in the listed directories and their\n\tsubdirectories. Otherwise, only the listed directories are updated. Build files\n\tin parent directories are still read for configuration.")
+	walkNestedRepos := fs.Bool("walk_nested_repos", false, "if true, directories containing WORKSPACE or go.mod files are treated as part of\n\tthe current repository. Otherwise, Gazelle does not generate rules in them,\n\tand imports of their packages are resolved as separate repositories.")
+	var excludes multiFlag
+	fs.Var(&excludes, "exclude", "pattern for files and directories that Gazelle should skip, relative to\n\tthe repository root. May be repeated. Patterns without a slash match base names\n\tanywhere in the repository.")
+	followSymlinks := fs.Bool("follow_symlinks", false, "if true, Gazelle follows symbolic links to directories inside the repository root.")
+	threeWay := fs.Bool("three_way", false, "if true, Gazelle records the content it generates for each build file under\n\t.gazelle in the repository root and uses the record as the base of a three-way merge the next time\n\tit runs. Manual edits are kept without \"# keep\" comments, and conflicts are reported.\n\tRecords are only written in fix mode.")
+	checkDeps := fs.Bool("check_deps", false, "if true, Gazelle reports cycles in the dependency graph of the repository\n\tand exits with an error if there are any.")
+	var forbidDeps multiFlag
+	fs.Var(&forbidDeps, "forbid_deps", "from=to: report dependencies of targets matching the pattern \"from\" on targets\n\tmatching \"to\", for example, //lib/...=//cmd/... May be repeated. Implies -check_deps.")
+	watchMode := fs.Bool("watch", false, "if true, Gazelle keeps running after it updates build files, watches the\n\trepository for changes to source files, and updates build files again when they\n\tchange. Only valid in fix mode.")
 	mode := fs.String("mode", "fix", "print: prints all of the updated BUILD files\n\tfix: rewrites all of the BUILD files in place\n\tdiff: computes the rewrite but then just does a diff")
 	if err := fs.Parse(args); err != nil {
 		if err == flag.ErrHelp {
@@ -170,40 +213,8 @@ func newConfiguration(args []string) (*config.Config, emitFunc, error) {
 	var c config.Config
 	var err error
 
-	c.Dirs = flag.Args()
-	if len(c.Dirs) == 0 {
-		c.Dirs = []string{"."}
-	}
-	for i := range c.Dirs {
-		c.Dirs[i], err = filepath.Abs(c.Dirs[i])
-		if err != nil {
-			return nil, nil, err
-		}
-	}
-
-	if *repoRoot != "" {
-		c.RepoRoot = *repoRoot
-	} else if len(c.Dirs) == 1 {
-		c.RepoRoot, err = wspace.Find(c.Dirs[0])
-		if err != nil {
-			return nil, nil, fmt.Errorf("-repo_root not specified, and WORKSPACE cannot be found: %v", err)
-		}
-	} else {
-		cwd, err := filepath.Abs(".")
-		if err != nil {
-			return nil, nil, err
-		}
-		c.RepoRoot, err = wspace.Find(cwd)
-		if err != nil {
-			return nil, nil, fmt.Errorf("-repo_root not specified, and WORKSPACE cannot be found: %v", err)
-		}
-	}
-
-	for _, dir := range c.Dirs {
-		if !isDescendingDir(dir, c.RepoRoot) {
-			return nil, nil, fmt.Errorf("dir %q is not a subdirectory of repo root %q", dir, c.RepoRoot)
-		}
-	}
+	c.Dirs = fs.Args()
+	c.RepoRoot = *repoRoot
 
 	c.ValidBuildFileNames = strings.Split(*buildFileName, ",")
 	if len(c.ValidBuildFileNames) == 0 {
@@ -217,87 +228,97 @@ func newConfiguration(args []string) (*config.Config, emitFunc, error) {
 		}
 		c.GenericTags[t] = true
 	}
-	c.Platforms = config.DefaultPlatformTags
-	c.PreprocessTags()
 
 	c.GoPrefix = *goPrefix
-	if c.GoPrefix == "" {
-		c.GoPrefix, err = loadGoPrefix(&c)
-		if err != nil {
-			return nil, nil, fmt.Errorf("-go_prefix not set and not root BUILD file found")
+	// These affect which directories SetDefaults scans for nested
+	// repositories and import comments, so set them first.
+	c.NonRecursive = !*recursive
+	c.WalkNestedRepos = *walkNestedRepos
+	c.Excludes = excludes
+	c.FollowSymlinks = *followSymlinks
+
+	// In fix mode, if someone is at the terminal, offer to create files
+	// needed to bootstrap a new repository.
+	bootstrap := *mode == "fix" && isInteractive()
+	err = runner.SetDefaults(&c)
+	if err == runner.ErrNoWorkspace && bootstrap {
+		wd, wdErr := os.Getwd()
+		if wdErr != nil {
+			return nil, nil, wdErr
+		}
+		if !confirm(fmt.Sprintf("No WORKSPACE file found. Create one in %s?", wd)) {
+			return nil, nil, err
 		}
+		if err := runner.CreateWorkspace(wd); err != nil {
+			return nil, nil, err
+		}
+		c.RepoRoot = wd
+		err = runner.SetDefaults(&c)
 	}
-
-	c.DepMode, err = config.DependencyModeFromString(*external)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	emit, ok := modeFromName[*mode]
-	if !ok {
-		return nil, nil, fmt.Errorf("unrecognized emit mode: %q", *mode)
-	}
-
-	return &c, emit, err
-}
-
-func findBuildFile(c *config.Config, dir string) (string, error) {
-	for _, base := range c.ValidBuildFileNames {
-		p := filepath.Join(dir, base)
-		fi, err := os.Stat(p)
-		if err == nil {
-			if fi.Mode().IsRegular() {
-				return p, nil
+	if *goPrefix == "" && bootstrap {
+		if _, err := runner.LoadGoPrefix(&c); err != nil && confirm(fmt.Sprintf("Add go_prefix(%q) to the root BUILD file?", c.GoPrefix)) {
+			if err := runner.WriteGoPrefix(&c); err != nil {
+				return nil, nil, err
 			}
-			continue
-		}
-		if !os.IsNotExist(err) {
-			return "", err
 		}
 	}
-	return "", os.ErrNotExist
-}
 
-func loadGoPrefix(c *config.Config) (string, error) {
-	p, err := findBuildFile(c, c.RepoRoot)
-	if err != nil {
-		return "", err
-	}
-	b, err := ioutil.ReadFile(p)
-	if err != nil {
-		return "", err
-	}
-	f, err := bzl.Parse(p, b)
+	c.DepMode, err = config.DependencyModeFromString(*external)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	for _, s := range f.Stmt {
-		c, ok := s.(*bzl.CallExpr)
-		if !ok {
-			continue
-		}
-		l, ok := c.X.(*bzl.LiteralExpr)
-		if !ok {
-			continue
+	if len(externalOverrides) > 0 {
+		c.DepModeOverrides = make(map[string]config.DependencyMode)
+		for _, o := range externalOverrides {
+			i := strings.LastIndex(o, "=")
+			if i < 0 {
+				return nil, nil, fmt.Errorf("-external_override %q: want importpath=mode", o)
+			}
+			mode, err := config.DependencyModeFromString(o[i+1:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("-external_override %q: %v", o, err)
+			}
+			c.DepModeOverrides[strings.TrimSuffix(o[:i], "/")] = mode
 		}
-		if l.Token != "go_prefix" {
-			continue
+	}
+
+	c.ThreeWayMerge = *threeWay
+	c.WriteRecords = *threeWay && *mode == "fix"
+
+	var opts options
+	for _, f := range forbidDeps {
+		r, err := graph.ParseLayerRule(f)
+		if err != nil {
+			return nil, nil, err
 		}
-		if len(c.List) != 1 {
-			return "", fmt.Errorf("found go_prefix(%v) with too many args", c.List)
+		opts.layerRules = append(opts.layerRules, r)
+	}
+	if *checkDeps || len(opts.layerRules) > 0 {
+		if c.NonRecursive {
+			return nil, nil, fmt.Errorf("-check_deps and -forbid_deps can't be used with -r=false; the whole dependency graph is needed")
 		}
-		v, ok := c.List[0].(*bzl.StringExpr)
-		if !ok {
-			return "", fmt.Errorf("found go_prefix(%v) which is not a string", c.List)
+		if *watchMode {
+			return nil, nil, fmt.Errorf("-check_deps and -forbid_deps can't be used with -watch")
 		}
-		return v.Value, nil
+		opts.depGraph = graph.New()
 	}
-	return "", errors.New("-go_prefix not set, and no go_prefix in root BUILD file")
-}
 
-func isDescendingDir(dir, root string) bool {
-	if dir == root {
-		return true
+	if *watchMode && *mode != "fix" {
+		return nil, nil, fmt.Errorf("-watch is only valid in fix mode")
+	}
+	if *watchMode && c.NonRecursive {
+		return nil, nil, fmt.Errorf("-watch can't be used with -r=false")
 	}
-	return strings.HasPrefix(dir, fmt.Sprintf("%s%c", root, filepath.Separator))
+
+	emit, ok := modeFromName[*mode]
+	if !ok {
+		return nil, nil, fmt.Errorf("unrecognized emit mode: %q", *mode)
+	}
+	opts.emit = emit
+	opts.watch = *watchMode
+
+	return &c, &opts, err
 }
@@ -0,0 +1,101 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+func TestNewConfiguration(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _, err := newConfiguration([]string{
+		"-repo_root", dir,
+		"-go_prefix", "example.com/repo",
+		"-exclude", "docs",
+		"-exclude", "gen/*",
+		"-external", "hybrid",
+		"-external_override", "github.com/foo/bar=external",
+		sub,
+	})
+	if err != nil {
+		t.Fatalf("newConfiguration failed with %v; want success", err)
+	}
+	if got, want := c.Dirs, []string{sub}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got dirs %q; want %q", got, want)
+	}
+	if got, want := c.Excludes, []string{"docs", "gen/*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got excludes %q; want %q", got, want)
+	}
+	if got, want := c.GoPrefix, "example.com/repo"; got != want {
+		t.Errorf("got prefix %q; want %q", got, want)
+	}
+	if got, want := c.DepMode, config.HybridMode; got != want {
+		t.Errorf("got dep mode %v; want %v", got, want)
+	}
+	if got, want := c.DepModeOverrides, map[string]config.DependencyMode{"github.com/foo/bar": config.ExternalMode}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got dep mode overrides %v; want %v", got, want)
+	}
+}
+
+func TestNewConfigurationExcludesBeforeInference(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []struct{ path, content string }{
+		{"WORKSPACE", ""},
+		{"a/a.go", `package a // import "wrong.example.com/a"`},
+		{"lib/lib.go", `package lib // import "example.com/repo/lib"`},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, _, err := newConfiguration([]string{
+		"-repo_root", dir,
+		"-exclude", "a",
+		dir,
+	})
+	if err != nil {
+		t.Fatalf("newConfiguration failed with %v; want success", err)
+	}
+	if got, want := c.GoPrefix, "example.com/repo"; got != want {
+		t.Errorf("got prefix %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,274 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package graph records dependencies between targets in a repository as
+// Gazelle resolves imports. It finds cycles, which Bazel would reject, and
+// checks dependencies against layering rules.
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Edge is a dependency of one target on another, both in the current
+// repository.
+type Edge struct {
+	// From and To are absolute labels, for example, "//lib:go_default_library".
+	// To is the label of a dependency of From.
+	From, To string
+
+	// Import is the import path that was resolved to To.
+	Import string
+
+	// File is the slash-separated path of the source file that contains the
+	// import, relative to the repository root. It is empty if the file is
+	// not known.
+	File string
+}
+
+func (e Edge) String() string {
+	if e.File == "" {
+		return fmt.Sprintf("%s imports %q (%s)", e.From, e.Import, e.To)
+	}
+	return fmt.Sprintf("%s imports %q in %s (%s)", e.From, e.Import, e.File, e.To)
+}
+
+// Graph is a directed graph of dependencies between targets. The zero
+// value is not usable; use New to create a Graph.
+type Graph struct {
+	edges map[string]map[string]Edge
+}
+
+// New returns an empty graph.
+func New() *Graph {
+	return &Graph{edges: make(map[string]map[string]Edge)}
+}
+
+// Add records a dependency. If there is already an edge between the same
+// targets, it is kept.
+func (g *Graph) Add(e Edge) {
+	out, ok := g.edges[e.From]
+	if !ok {
+		out = make(map[string]Edge)
+		g.edges[e.From] = out
+	}
+	if _, ok := out[e.To]; !ok {
+		out[e.To] = e
+	}
+	if _, ok := g.edges[e.To]; !ok {
+		g.edges[e.To] = make(map[string]Edge)
+	}
+}
+
+// Edges returns all edges in the graph, sorted by From, then To.
+func (g *Graph) Edges() []Edge {
+	var edges []Edge
+	for _, from := range g.nodes() {
+		for _, to := range g.succs(from) {
+			edges = append(edges, g.edges[from][to])
+		}
+	}
+	return edges
+}
+
+// Cycles returns one cycle for each group of targets that depend on each
+// other (each strongly connected component with more than one target, or
+// with a target that depends on itself). Each cycle is a list of edges that
+// starts and ends at the smallest label in the group.
+func (g *Graph) Cycles() [][]Edge {
+	var cycles [][]Edge
+	for _, scc := range g.components() {
+		start := scc[0]
+		inSCC := make(map[string]bool)
+		for _, n := range scc {
+			inSCC[n] = true
+		}
+		if len(scc) == 1 {
+			if e, ok := g.edges[start][start]; ok {
+				cycles = append(cycles, []Edge{e})
+			}
+			continue
+		}
+		cycles = append(cycles, g.cycleFrom(start, inSCC))
+	}
+	return cycles
+}
+
+// cycleFrom returns a path of edges from start back to itself through the
+// nodes in inSCC, which must be a strongly connected component.
+func (g *Graph) cycleFrom(start string, inSCC map[string]bool) []Edge {
+	visited := make(map[string]bool)
+	var path []Edge
+	var visit func(string) bool
+	visit = func(n string) bool {
+		visited[n] = true
+		for _, to := range g.succs(n) {
+			if !inSCC[to] {
+				continue
+			}
+			if to == start {
+				path = append(path, g.edges[n][to])
+				return true
+			}
+			if visited[to] {
+				continue
+			}
+			path = append(path, g.edges[n][to])
+			if visit(to) {
+				return true
+			}
+			path = path[:len(path)-1]
+		}
+		return false
+	}
+	visit(start)
+	return path
+}
+
+// components returns the strongly connected components of the graph using
+// Tarjan's algorithm. Nodes in each component are sorted, and components
+// are sorted by their first node.
+func (g *Graph) components() [][]string {
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var sccs [][]string
+
+	var connect func(string)
+	connect = func(n string) {
+		index[n] = len(index)
+		low[n] = index[n]
+		stack = append(stack, n)
+		onStack[n] = true
+		for _, to := range g.succs(n) {
+			if _, ok := index[to]; !ok {
+				connect(to)
+				if low[to] < low[n] {
+					low[n] = low[to]
+				}
+			} else if onStack[to] && index[to] < low[n] {
+				low[n] = index[to]
+			}
+		}
+		if low[n] != index[n] {
+			return
+		}
+		var scc []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			scc = append(scc, top)
+			if top == n {
+				break
+			}
+		}
+		sort.Strings(scc)
+		sccs = append(sccs, scc)
+	}
+
+	for _, n := range g.nodes() {
+		if _, ok := index[n]; !ok {
+			connect(n)
+		}
+	}
+	sort.Slice(sccs, func(i, j int) bool { return sccs[i][0] < sccs[j][0] })
+	return sccs
+}
+
+func (g *Graph) nodes() []string {
+	nodes := make([]string, 0, len(g.edges))
+	for n := range g.edges {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func (g *Graph) succs(n string) []string {
+	succs := make([]string, 0, len(g.edges[n]))
+	for to := range g.edges[n] {
+		succs = append(succs, to)
+	}
+	sort.Strings(succs)
+	return succs
+}
+
+// LayerRule forbids targets matching From from depending on targets
+// matching To. Both are target patterns: "//pkg:name" or "//pkg" match
+// targets in a single package (the name is ignored), "//pkg/..." matches
+// targets in pkg and its subpackages, and "//..." matches everything.
+type LayerRule struct {
+	From, To string
+}
+
+func (r LayerRule) String() string {
+	return r.From + "=" + r.To
+}
+
+// ParseLayerRule parses a rule of the form "from=to", where from and to are
+// target patterns as described in LayerRule.
+func ParseLayerRule(s string) (LayerRule, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return LayerRule{}, fmt.Errorf("layering rule %q: want from=to", s)
+	}
+	r := LayerRule{From: s[:i], To: s[i+1:]}
+	for _, p := range []string{r.From, r.To} {
+		if !strings.HasPrefix(p, "//") {
+			return LayerRule{}, fmt.Errorf("layering rule %q: pattern %q must start with //", s, p)
+		}
+	}
+	return r, nil
+}
+
+// Check returns the edges that violate any of the given rules, sorted by
+// From, then To.
+func (g *Graph) Check(rules []LayerRule) []Edge {
+	var violations []Edge
+	for _, e := range g.Edges() {
+		for _, r := range rules {
+			if matchPattern(r.From, e.From) && matchPattern(r.To, e.To) {
+				violations = append(violations, e)
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// matchPattern returns whether the absolute label "label" matches the
+// target pattern "pattern".
+func matchPattern(pattern, label string) bool {
+	pkg := labelPackage(label)
+	if strings.HasSuffix(pattern, "...") {
+		prefix := strings.TrimSuffix(strings.TrimPrefix(pattern, "//"), "...")
+		prefix = strings.TrimSuffix(prefix, "/")
+		return prefix == "" || pkg == prefix || strings.HasPrefix(pkg, prefix+"/")
+	}
+	return pkg == labelPackage(pattern)
+}
+
+// labelPackage returns the package part of an absolute label.
+func labelPackage(label string) string {
+	label = strings.TrimPrefix(label, "//")
+	if i := strings.Index(label, ":"); i >= 0 {
+		label = label[:i]
+	}
+	return label
+}
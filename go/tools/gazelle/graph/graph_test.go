@@ -0,0 +1,98 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func edge(from, to string) Edge {
+	return Edge{From: from, To: to, Import: "example.com/repo/" + labelPackage(to)}
+}
+
+func TestCycles(t *testing.T) {
+	g := New()
+	for _, e := range []Edge{
+		edge("//a:go_default_library", "//b:go_default_library"),
+		edge("//b:go_default_library", "//c:go_default_library"),
+		edge("//c:go_default_library", "//a:go_default_library"),
+		edge("//c:go_default_library", "//d:go_default_library"),
+		edge("//d:go_default_library", "//d:go_default_library"),
+		edge("//e:go_default_library", "//a:go_default_library"),
+	} {
+		g.Add(e)
+	}
+	want := [][]Edge{
+		{
+			edge("//a:go_default_library", "//b:go_default_library"),
+			edge("//b:go_default_library", "//c:go_default_library"),
+			edge("//c:go_default_library", "//a:go_default_library"),
+		},
+		{
+			edge("//d:go_default_library", "//d:go_default_library"),
+		},
+	}
+	if got := g.Cycles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got cycles %v; want %v", got, want)
+	}
+}
+
+func TestNoCycles(t *testing.T) {
+	g := New()
+	g.Add(edge("//a:go_default_library", "//b:go_default_library"))
+	g.Add(edge("//a:go_default_xtest", "//a:go_default_library"))
+	if got := g.Cycles(); len(got) != 0 {
+		t.Errorf("got cycles %v; want none", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	g := New()
+	for _, e := range []Edge{
+		edge("//lib:go_default_library", "//cmd/tool:go_default_library"),
+		edge("//lib/sub:go_default_library", "//cmd:go_default_library"),
+		edge("//library:go_default_library", "//cmd:go_default_library"),
+		edge("//cmd:go_default_library", "//lib:go_default_library"),
+		edge("//x:go_default_library", "//internal/y:go_default_library"),
+	} {
+		g.Add(e)
+	}
+	var rules []LayerRule
+	for _, s := range []string{"//lib/...=//cmd/...", "//x=//internal/..."} {
+		r, err := ParseLayerRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	want := []Edge{
+		edge("//lib/sub:go_default_library", "//cmd:go_default_library"),
+		edge("//lib:go_default_library", "//cmd/tool:go_default_library"),
+		edge("//x:go_default_library", "//internal/y:go_default_library"),
+	}
+	if got := g.Check(rules); !reflect.DeepEqual(got, want) {
+		t.Errorf("got violations %v; want %v", got, want)
+	}
+}
+
+func TestParseLayerRuleErrors(t *testing.T) {
+	for _, s := range []string{"//lib/...", "lib=//cmd", "//lib=cmd/..."} {
+		if r, err := ParseLayerRule(s); err == nil {
+			t.Errorf("ParseLayerRule(%q) = %v; want error", s, r)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package lang defines the interface Gazelle uses to generate rules for
+// each language. Go is one implementation; others can be registered to run
+// in the same walk over the repository.
+package lang
+
+import (
+	"sort"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/graph"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+)
+
+// Language generates and resolves rules for one language. Gazelle calls
+// each registered language for every directory it visits, and the rules
+// from all languages are merged into the same build file.
+type Language interface {
+	// Name returns a short, unique name for the language, for example, "go".
+	// It is used as a key in config.Config.Exts.
+	Name() string
+
+	// Loads returns information about the .bzl files that provide the rule
+	// kinds this language generates.
+	Loads() []LoadInfo
+
+	// Configure is called for each directory before rules are generated in it
+	// or its subdirectories. "c" is a copy of the parent directory's
+	// configuration, which the language may modify. "f" is the existing build
+	// file in the directory, or nil if there is none.
+	Configure(c *config.Config, rel string, f *bzl.File)
+
+	// GenerateRules generates rules for the files in directory "d". It
+	// returns a list of rules and a parallel list of imports for each rule.
+	// The imports are passed back to Resolve after all rules for the directory
+	// have been generated; they may be nil for rules with no dependencies.
+	GenerateRules(c *config.Config, d *packages.Dir) (rules []*bzl.Rule, imports []interface{})
+
+	// Resolve converts the imports of a rule returned by GenerateRules into
+	// dependencies and sets the corresponding attributes of "r". "rel" is the
+	// slash-separated path to the directory containing the rule. It returns
+	// the dependencies on other targets in the repository, which Gazelle may
+	// use to check the dependency graph; nil may be returned if they aren't
+	// known.
+	Resolve(c *config.Config, rel string, r *bzl.Rule, imports interface{}) []graph.Edge
+}
+
+// LoadInfo describes a .bzl file and the rule kinds it provides.
+type LoadInfo struct {
+	// Name is the label of the .bzl file, for example,
+	// "@io_bazel_rules_go//go:def.bzl".
+	Name string
+
+	// Kinds is a list of rule kinds loaded from the file.
+	Kinds []string
+}
+
+var registered []Language
+
+// Register adds a language to the list of languages Gazelle generates rules
+// for. It is usually called from an init function. Languages are called in
+// the order they were registered.
+func Register(l Language) {
+	registered = append(registered, l)
+}
+
+// Languages returns the list of registered languages.
+func Languages() []Language {
+	return registered
+}
+
+// GenerateLoads returns load statements for the rule kinds used in "rules".
+// One statement is returned for each LoadInfo that provides at least one
+// of the kinds used. Symbols in each statement are sorted.
+func GenerateLoads(loads []LoadInfo, rules []*bzl.Rule) []bzl.Expr {
+	used := make(map[string]bool)
+	for _, r := range rules {
+		used[r.Kind()] = true
+	}
+
+	var stmts []bzl.Expr
+	for _, l := range loads {
+		var kinds []string
+		for _, k := range l.Kinds {
+			if used[k] {
+				kinds = append(kinds, k)
+			}
+		}
+		if len(kinds) == 0 {
+			continue
+		}
+		sort.Strings(kinds)
+		args := make([]bzl.Expr, 0, len(kinds)+1)
+		args = append(args, &bzl.StringExpr{Value: l.Name})
+		for _, k := range kinds {
+			args = append(args, &bzl.StringExpr{Value: k})
+		}
+		stmts = append(stmts, &bzl.CallExpr{
+			X:            &bzl.LiteralExpr{Token: "load"},
+			List:         args,
+			ForceCompact: true,
+		})
+	}
+	return stmts
+}
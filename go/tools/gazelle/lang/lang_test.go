@@ -0,0 +1,42 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lang
+
+import (
+	"testing"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+func TestGenerateLoads(t *testing.T) {
+	loads := []LoadInfo{
+		{Name: "@io_bazel_rules_go//go:def.bzl", Kinds: []string{"go_library", "go_binary", "go_test"}},
+		{Name: "@io_bazel_rules_go//proto:go_proto_library.bzl", Kinds: []string{"go_proto_library"}},
+		{Name: "//tools:sh.bzl", Kinds: []string{"sh_lint"}},
+	}
+	var rs []*bzl.Rule
+	for _, kind := range []string{"go_test", "go_library", "sh_lint", "cc_library"} {
+		rs = append(rs, &bzl.Rule{Call: &bzl.CallExpr{X: &bzl.LiteralExpr{Token: kind}}})
+	}
+	f := &bzl.File{Stmt: GenerateLoads(loads, rs)}
+	got := string(bzl.Format(f))
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+load("//tools:sh.bzl", "sh_lint")
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
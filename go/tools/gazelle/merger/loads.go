@@ -0,0 +1,293 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package merger
+
+import (
+	"sort"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// Load describes a .bzl file and the rule kinds Gazelle may load from it.
+type Load struct {
+	// Name is the label of the .bzl file, for example,
+	// "@io_bazel_rules_go//go:def.bzl".
+	Name string
+
+	// Kinds is a list of rule kinds that may be loaded from the file.
+	Kinds []string
+}
+
+// loadSymbol is a symbol loaded by a load statement. "local" is the name
+// bound in the build file, and "exported" is the name in the .bzl file.
+// They are different for aliased symbols, like go_lib = "go_library".
+type loadSymbol struct {
+	local, exported string
+	expr            bzl.Expr
+}
+
+// loadSymbols returns the symbols loaded by the load statement "c".
+func loadSymbols(c *bzl.CallExpr) []loadSymbol {
+	var syms []loadSymbol
+	for _, arg := range c.List[1:] {
+		switch arg := arg.(type) {
+		case *bzl.StringExpr:
+			syms = append(syms, loadSymbol{local: arg.Value, exported: arg.Value, expr: arg})
+		case *bzl.BinaryExpr:
+			if arg.Op != "=" {
+				continue
+			}
+			local, ok := arg.X.(*bzl.LiteralExpr)
+			if !ok {
+				continue
+			}
+			syms = append(syms, loadSymbol{local: local.Token, exported: stringValue(arg.Y), expr: arg})
+		}
+	}
+	return syms
+}
+
+// newLoadSymbol returns the expression for a symbol in a load statement.
+func newLoadSymbol(local, exported string) bzl.Expr {
+	if local == exported {
+		return &bzl.StringExpr{Value: exported}
+	}
+	return &bzl.BinaryExpr{
+		X:  &bzl.LiteralExpr{Token: local},
+		Op: "=",
+		Y:  &bzl.StringExpr{Value: exported},
+	}
+}
+
+// isLoad returns whether "s" is a load statement with a label.
+func isLoad(s bzl.Expr) bool {
+	c, ok := s.(*bzl.CallExpr)
+	return ok && kind(c) == "load" && len(c.List) > 0
+}
+
+// loadAliases returns a map from local names to exported names for symbols
+// in "f" that are loaded with a different name.
+func loadAliases(f *bzl.File) map[string]string {
+	aliases := make(map[string]string)
+	for _, s := range f.Stmt {
+		if !isLoad(s) {
+			continue
+		}
+		for _, sym := range loadSymbols(s.(*bzl.CallExpr)) {
+			if sym.local != sym.exported {
+				aliases[sym.local] = sym.exported
+			}
+		}
+	}
+	return aliases
+}
+
+// usedNames returns the set of identifiers used in "f" outside of load
+// statements. This includes the kinds of rules and macros.
+func usedNames(f *bzl.File) map[string]bool {
+	used := make(map[string]bool)
+	for _, s := range f.Stmt {
+		if isLoad(s) {
+			continue
+		}
+		bzl.Walk(s, func(x bzl.Expr, _ []bzl.Expr) {
+			if l, ok := x.(*bzl.LiteralExpr); ok {
+				used[l.Token] = true
+			}
+		})
+	}
+	return used
+}
+
+// FixLoads updates the load statements in "f" after rules have been
+// merged or added. "genLoads" are the load statements in the generated
+// file, if any, and "loads" lists all the files Gazelle may load kinds from.
+//
+// Files named in "genLoads" or "loads" are managed by Gazelle. Symbols
+// loaded from managed files are removed if they are not used. Symbols that
+// Gazelle now loads from a different managed file are moved to that file.
+// Symbols for generated kinds are added to an existing load statement for
+// the right file, or to a new load statement after the existing ones. Load
+// statements with no symbols left are deleted. Aliased symbols are kept as
+// long as their local names are used.
+func FixLoads(f *bzl.File, genLoads []*bzl.CallExpr, loads []Load) {
+	managed := make(map[string]bool)
+	known := make(map[string]string) // exported kind -> label
+	for _, l := range loads {
+		managed[l.Name] = true
+		for _, k := range l.Kinds {
+			known[k] = l.Name
+		}
+	}
+	for _, c := range genLoads {
+		label := stringValue(c.List[0])
+		managed[label] = true
+		for _, sym := range loadSymbols(c) {
+			known[sym.exported] = label
+		}
+	}
+	used := usedNames(f)
+
+	// Remove symbols that are not used or that moved to a different file.
+	type movedSymbol struct {
+		label string
+		sym   loadSymbol
+	}
+	var moved []movedSymbol
+	loaded := make(map[string]bool)
+	changed := make(map[*bzl.CallExpr]bool)
+	for i, s := range f.Stmt {
+		if !isLoad(s) {
+			continue
+		}
+		c := s.(*bzl.CallExpr)
+		label := stringValue(c.List[0])
+		if !managed[label] {
+			for _, sym := range loadSymbols(c) {
+				loaded[sym.local] = true
+			}
+			continue
+		}
+		// Replace the statement with a copy. The old file's statements may
+		// also be used as the base or record, so they must not be modified.
+		merged := *c
+		merged.List = []bzl.Expr{c.List[0]}
+		for _, sym := range loadSymbols(c) {
+			if !used[sym.local] {
+				changed[&merged] = true
+				continue
+			}
+			if to, ok := known[sym.exported]; ok && to != label {
+				changed[&merged] = true
+				moved = append(moved, movedSymbol{to, sym})
+				continue
+			}
+			merged.List = append(merged.List, sym.expr)
+			loaded[sym.local] = true
+		}
+		f.Stmt[i] = &merged
+	}
+
+	// Add symbols that are needed and not loaded yet.
+	var needed []movedSymbol
+	for _, m := range moved {
+		if !loaded[m.sym.local] {
+			needed = append(needed, m)
+			loaded[m.sym.local] = true
+		}
+	}
+	for _, c := range genLoads {
+		label := stringValue(c.List[0])
+		for _, sym := range loadSymbols(c) {
+			if used[sym.local] && !loaded[sym.local] {
+				needed = append(needed, movedSymbol{label, sym})
+				loaded[sym.local] = true
+			}
+		}
+	}
+	var newLoads []bzl.Expr
+	for _, n := range needed {
+		load := findLoad(f.Stmt, n.label)
+		if load == nil {
+			load = findLoad(newLoads, n.label)
+		}
+		if load == nil {
+			load = &bzl.CallExpr{
+				X:            &bzl.LiteralExpr{Token: "load"},
+				List:         []bzl.Expr{&bzl.StringExpr{Value: n.label}},
+				ForceCompact: true,
+			}
+			newLoads = append(newLoads, load)
+		}
+		load.List = append(load.List, newLoadSymbol(n.sym.local, n.sym.exported))
+		changed[load] = true
+	}
+
+	// Sort symbols in changed statements and delete empty statements.
+	stmt := f.Stmt[:0]
+	for _, s := range f.Stmt {
+		if isLoad(s) {
+			c := s.(*bzl.CallExpr)
+			if len(c.List) == 1 {
+				continue
+			}
+			if changed[c] {
+				sortLoadSymbols(c)
+				c.ForceCompact = true
+			}
+		}
+		stmt = append(stmt, s)
+	}
+	f.Stmt = stmt
+	for _, s := range newLoads {
+		sortLoadSymbols(s.(*bzl.CallExpr))
+	}
+
+	// Put new load statements after existing ones, or before the first rule
+	// if there are none.
+	at := -1
+	for i, s := range f.Stmt {
+		if isLoad(s) {
+			at = i + 1
+		}
+	}
+	if at < 0 {
+		at = len(f.Stmt)
+		for i, s := range f.Stmt {
+			if _, ok := s.(*bzl.CallExpr); ok {
+				at = i
+				break
+			}
+		}
+	}
+	stmt = append([]bzl.Expr{}, f.Stmt[:at]...)
+	stmt = append(stmt, newLoads...)
+	f.Stmt = append(stmt, f.Stmt[at:]...)
+}
+
+// findLoad returns the first load statement in "stmts" for the file
+// "label", or nil if there is none.
+func findLoad(stmts []bzl.Expr, label string) *bzl.CallExpr {
+	for _, s := range stmts {
+		if isLoad(s) && stringValue(s.(*bzl.CallExpr).List[0]) == label {
+			return s.(*bzl.CallExpr)
+		}
+	}
+	return nil
+}
+
+// sortLoadSymbols sorts the symbols loaded by "c" by local name. Aliased
+// symbols are put after other symbols.
+func sortLoadSymbols(c *bzl.CallExpr) {
+	syms := c.List[1:]
+	sort.SliceStable(syms, func(i, j int) bool {
+		_, iAlias := syms[i].(*bzl.BinaryExpr)
+		_, jAlias := syms[j].(*bzl.BinaryExpr)
+		if iAlias != jAlias {
+			return jAlias
+		}
+		return loadSymbolName(syms[i]) < loadSymbolName(syms[j])
+	})
+}
+
+func loadSymbolName(e bzl.Expr) string {
+	if b, ok := e.(*bzl.BinaryExpr); ok {
+		if l, ok := b.X.(*bzl.LiteralExpr); ok {
+			return l.Token
+		}
+	}
+	return stringValue(e)
+}
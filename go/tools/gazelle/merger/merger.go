@@ -32,9 +32,33 @@ const (
 
 var (
 	mergeableFields = map[string]bool{
-		"srcs":    true,
-		"deps":    true,
-		"library": true,
+		"srcs":       true,
+		"deps":       true,
+		"library":    true,
+		"xtest_srcs": true,
+	}
+
+	// unionFields are lists that may be edited by hand and extended by
+	// Gazelle. Existing elements are kept, and generated elements are added.
+	// Existing elements listed in ownedValues are only kept if they are
+	// still generated or they are marked with "# keep".
+	unionFields = map[string]bool{
+		"tags": true,
+	}
+
+	// ownedValues are values of unionFields that only Gazelle generates.
+	ownedValues = map[string]map[string]bool{
+		"tags": {"manual": true},
+	}
+
+	// scalarFields are attributes with single values that Gazelle may set
+	// from directives. A generated value replaces the existing value unless
+	// it is marked with "# keep". When no value is generated, the existing
+	// value is kept, since it may have been written by hand.
+	scalarFields = map[string]bool{
+		"shard_count": true,
+		"size":        true,
+		"timeout":     true,
 	}
 )
 
@@ -48,6 +72,39 @@ var (
 // a "# gazelle:ignore" comment, nil will be returned. If an error occurs,
 // it will be logged, and nil will be returned.
 func MergeWithExisting(genFile, oldFile *bzl.File) *bzl.File {
+	return MergeWithBase(genFile, nil, oldFile, Options{})
+}
+
+// Options control how rule kinds and load statements are merged. The zero
+// value is valid.
+type Options struct {
+	// Kinds maps kinds of rules Gazelle generates to wrapper kinds that were
+	// generated instead (see config.MappedKind). Existing rules of either
+	// kind match generated rules of the wrapper kind, and they are changed
+	// to the wrapper kind.
+	Kinds map[string]string
+
+	// Loads lists the files Gazelle may load rule kinds from. Symbols for
+	// these kinds are removed from load statements when they are no longer
+	// used, even if no rules are generated from the same file.
+	Loads []Load
+}
+
+// MergeWithBase is like MergeWithExisting, but it performs a three-way
+// merge. "baseFile" is the file Gazelle generated the last time it was run,
+// before it was merged with the existing file. It may be nil if there is no
+// record, in which case the merge is the same as in MergeWithExisting.
+//
+// Changes made to "oldFile" since "baseFile" was generated are preserved:
+// elements added to lists and rules or attributes that were deleted are
+// kept as they are, even without "# keep" comments. Rules and attributes
+// that were not edited are updated to the generated values, and rules
+// Gazelle no longer generates are deleted. When both Gazelle and the user
+// changed the same attribute in different ways, the existing value is kept
+// and the conflict is logged.
+//
+// "opts" configures how rule kinds and load statements are merged.
+func MergeWithBase(genFile, baseFile, oldFile *bzl.File, opts Options) *bzl.File {
 	if oldFile == nil {
 		return genFile
 	}
@@ -61,38 +118,115 @@ func MergeWithExisting(genFile, oldFile *bzl.File) *bzl.File {
 		mergedFile.Stmt[i] = oldFile.Stmt[i]
 	}
 
+	// Rules match existing rules of kinds mapped with map_kind or loaded
+	// with an alias.
+	aliases := loadAliases(oldFile)
+	kinds := make(map[string]string)
+	for from, to := range opts.Kinds {
+		kinds[from] = to
+	}
+	for local, exported := range aliases {
+		kinds[local] = exported
+	}
+	aliasOf := make(map[string]string)
+	for local, exported := range aliases {
+		aliasOf[exported] = local
+	}
+
+	// Merge rules before load statements, since the symbols that are loaded
+	// depend on which kinds are used in the merged file.
 	var newStmt []bzl.Expr
+	var genLoads []*bzl.CallExpr
+	matched := make(map[int]bool)
 	for _, s := range genFile.Stmt {
 		genRule, ok := s.(*bzl.CallExpr)
 		if !ok {
 			log.Panicf("got %v expected only CallExpr in %q", s, genFile.Path)
 		}
-		i, oldRule := match(&mergedFile, genRule)
+		if kind(genRule) == "load" {
+			if len(genRule.List) > 0 {
+				genLoads = append(genLoads, genRule)
+			}
+			continue
+		}
+		baseRule := matchBase(baseFile, genRule, kinds)
+		i, oldRule := match(&mergedFile, genRule, kinds)
 		if oldRule == nil {
+			if baseRule != nil {
+				// The rule was deleted by hand.
+				if !equalRule(genRule, baseRule) {
+					log.Printf("%s: conflict: rule %q was deleted, but it would be changed; not adding it", oldFile.Path, name(genRule))
+				}
+				continue
+			}
+			if alias, ok := aliasOf[kind(genRule)]; ok {
+				// Use the name the kind is loaded with.
+				r := *genRule
+				r.X = &bzl.LiteralExpr{Token: alias}
+				genRule = &r
+			}
 			newStmt = append(newStmt, genRule)
 			continue
 		}
-
-		var mergedRule bzl.Expr
-		if kind(oldRule) == "load" {
-			mergedRule = mergeLoad(genRule, oldRule, oldFile)
-		} else {
-			mergedRule = mergeRule(genRule, oldRule)
+		matched[i] = true
+		mergedRule := mergeRule(genRule, baseRule, oldRule, oldFile.Path)
+		if aliases[kind(oldRule)] == kind(genRule) {
+			mergedRule.X = oldRule.X
 		}
 		mergedFile.Stmt[i] = mergedRule
 	}
 
+	if baseFile != nil {
+		// Delete rules Gazelle generated before but doesn't generate anymore,
+		// unless they were edited by hand.
+		stmt := mergedFile.Stmt[:0]
+		for i, s := range mergedFile.Stmt {
+			if oldRule, ok := s.(*bzl.CallExpr); ok && !matched[i] && kind(oldRule) != "load" {
+				if baseRule := matchBase(baseFile, oldRule, kinds); baseRule != nil {
+					if equalRule(oldRule, baseRule) {
+						continue
+					}
+					log.Printf("%s: conflict: rule %q is no longer generated, but it was edited; keeping it", oldFile.Path, name(oldRule))
+				}
+			}
+			stmt = append(stmt, s)
+		}
+		mergedFile.Stmt = stmt
+	}
+
 	mergedFile.Stmt = append(mergedFile.Stmt, newStmt...)
+	FixLoads(&mergedFile, genLoads, opts.Loads)
 	return &mergedFile
 }
 
+// matchBase returns the rule in "baseFile" that matches "c". nil is
+// returned if "baseFile" is nil or there is no match.
+func matchBase(baseFile *bzl.File, c *bzl.CallExpr, kinds map[string]string) *bzl.CallExpr {
+	if baseFile == nil {
+		return nil
+	}
+	_, r := match(baseFile, c, kinds)
+	return r
+}
+
 // merge combines information from gen and old and returns an updated rule.
 // Both rules must be non-nil and must have the same kind and same name.
-func mergeRule(gen, old *bzl.CallExpr) *bzl.CallExpr {
+// "base" is the rule previously generated by Gazelle. It may be nil if
+// there is no record of it. "path" is the path of the file being merged,
+// used to report conflicts.
+func mergeRule(gen, base, old *bzl.CallExpr, path string) *bzl.CallExpr {
 	genRule := bzl.Rule{Call: gen}
 	oldRule := bzl.Rule{Call: old}
+	var baseRule *bzl.Rule
+	if base != nil {
+		baseRule = &bzl.Rule{Call: base}
+	}
 	merged := *old
 	merged.List = nil
+	if kind(gen) != kind(old) {
+		// The existing rule has the kind a wrapper kind was mapped from.
+		merged.X = gen.X
+	}
 	mergedRule := bzl.Rule{Call: &merged}
 
 	// Copy unnamed arguments from the old rule without merging. The only rule
@@ -110,6 +244,37 @@ func mergeRule(gen, old *bzl.CallExpr) *bzl.CallExpr {
 	// Assume generated attributes have no comments.
 	for _, k := range oldRule.AttrKeys() {
 		oldAttr := oldRule.AttrDefn(k)
+		if baseRule != nil && baseRule.Attr(k) != nil {
+			if mergedExpr, ok := mergeAttr3(k, genRule.Attr(k), baseRule.Attr(k), oldAttr.Y); ok {
+				if mergedExpr != nil {
+					mergedAttr := *oldAttr
+					mergedAttr.Y = mergedExpr
+					merged.List = append(merged.List, &mergedAttr)
+				}
+			} else {
+				log.Printf("%s: conflict: attribute %q of rule %q was edited, but it would be changed; keeping it", path, k, oldRule.Name())
+				merged.List = append(merged.List, oldAttr)
+			}
+			continue
+		}
+		if unionFields[k] {
+			if union := unionList(genRule.Attr(k), oldAttr.Y, ownedValues[k]); union != nil {
+				mergedAttr := *oldAttr
+				mergedAttr.Y = union
+				merged.List = append(merged.List, &mergedAttr)
+			}
+			continue
+		}
+		if scalarFields[k] {
+			if genExpr := genRule.Attr(k); genExpr != nil && !shouldKeep(oldAttr) && !shouldKeep(oldAttr.Y) {
+				mergedAttr := *oldAttr
+				mergedAttr.Y = genExpr
+				merged.List = append(merged.List, &mergedAttr)
+			} else {
+				merged.List = append(merged.List, oldAttr)
+			}
+			continue
+		}
 		if !mergeableFields[k] {
 			merged.List = append(merged.List, oldAttr)
 			continue
@@ -131,9 +296,17 @@ func mergeRule(gen, old *bzl.CallExpr) *bzl.CallExpr {
 
 	// Merge attributes from genRule that we haven't processed already.
 	for _, k := range genRule.AttrKeys() {
-		if mergedRule.Attr(k) == nil {
-			mergedRule.SetAttr(k, genRule.Attr(k))
+		if mergedRule.Attr(k) != nil {
+			continue
+		}
+		if baseRule != nil && baseRule.Attr(k) != nil && oldRule.Attr(k) == nil {
+			// The attribute was deleted by hand.
+			if !equalExpr(genRule.Attr(k), baseRule.Attr(k)) {
+				log.Printf("%s: conflict: attribute %q of rule %q was deleted, but it would be changed; not adding it", path, k, oldRule.Name())
+			}
+			continue
 		}
+		mergedRule.SetAttr(k, genRule.Attr(k))
 	}
 
 	return &merged
@@ -145,10 +318,16 @@ func mergeRule(gen, old *bzl.CallExpr) *bzl.CallExpr {
 //   * nil
 //   * strings (can only be merged with strings)
 //   * lists of strings
-//   * a call to select with a dict argument. The dict keys must be strings,
-//     and the values must be lists of strings.
-//   * a list of strings combined with a select call using +. The list must
-//     be the left operand.
+//   * a call to select with a dict argument. The dict keys must be strings.
+//   * any concatenation of the above lists and selects using +.
+//
+// "gen" is in the form Gazelle generates: a list, a select, or a list
+// combined with a select. The first list in "old" is merged with the
+// generated list. The first select in "old" with keys for the platforms
+// Gazelle generates is merged with the generated select. Other lists and
+// selects, and cases for other conditions in a merged select, were written
+// by hand and are preserved. Generated strings that appear in other lists
+// are not added again.
 //
 // An error is returned if the expressions can't be merged, for example
 // because they are not in one of the above formats.
@@ -164,17 +343,45 @@ func mergeExpr(gen, old bzl.Expr) (bzl.Expr, error) {
 	if err != nil {
 		return nil, err
 	}
-	oldList, oldDict, err := exprListAndDict(old)
+	oldTerms, err := sumTerms(old)
 	if err != nil {
 		return nil, err
 	}
 
-	mergedList := mergeList(genList, oldList)
+	// Find the list and select Gazelle owns. Strings in other lists were
+	// added by hand.
+	listIndex, selectIndex := -1, -1
+	other := make(map[string]bool)
+	for i, t := range oldTerms {
+		if l, ok := t.(*bzl.ListExpr); ok {
+			if listIndex < 0 {
+				listIndex = i
+				continue
+			}
+			for _, v := range l.List {
+				if s := stringValue(v); s != "" {
+					other[s] = true
+				}
+			}
+		} else if selectIndex < 0 && isGeneratedSelect(selectDict(t), genDict) {
+			selectIndex = i
+		}
+	}
+
+	var oldList *bzl.ListExpr
+	if listIndex >= 0 {
+		oldList = oldTerms[listIndex].(*bzl.ListExpr)
+	}
+	mergedList := mergeList(withoutStrings(genList, other), oldList)
+
+	var oldDict *bzl.DictExpr
+	if selectIndex >= 0 {
+		oldDict = selectDict(oldTerms[selectIndex])
+	}
 	mergedDict, err := mergeDict(genDict, oldDict)
 	if err != nil {
 		return nil, err
 	}
-
 	var mergedSelect bzl.Expr
 	if mergedDict != nil {
 		mergedSelect = &bzl.CallExpr{
@@ -183,59 +390,194 @@ func mergeExpr(gen, old bzl.Expr) (bzl.Expr, error) {
 		}
 	}
 
-	if mergedList == nil {
-		return mergedSelect, nil
+	// Put the merged list and select where the old ones were. New lists go
+	// first, and new selects go last.
+	var terms []bzl.Expr
+	if listIndex < 0 && mergedList != nil {
+		terms = append(terms, mergedList)
+	}
+	for i, t := range oldTerms {
+		switch i {
+		case listIndex:
+			if mergedList != nil {
+				terms = append(terms, mergedList)
+			}
+		case selectIndex:
+			if mergedSelect != nil {
+				terms = append(terms, mergedSelect)
+			}
+		default:
+			terms = append(terms, t)
+		}
 	}
-	if mergedSelect == nil {
-		return mergedList, nil
+	if selectIndex < 0 && mergedSelect != nil {
+		terms = append(terms, mergedSelect)
 	}
-	mergedList.ForceMultiLine = true
-	return &bzl.BinaryExpr{
-		X:  mergedList,
-		Op: "+",
-		Y:  mergedSelect,
-	}, nil
-}
 
-// exprListAndDict matches an expression and attempts to extract either a list
-// of expressions, a call to select with a dictionary, or both.
-// An error is returned if the expression could not be matched.
-func exprListAndDict(expr bzl.Expr) (*bzl.ListExpr, *bzl.DictExpr, error) {
-	if expr == nil {
-		return nil, nil, nil
+	if len(terms) == 0 {
+		return nil, nil
 	}
-	switch expr := expr.(type) {
+	if len(terms) > 1 && mergedList != nil {
+		mergedList.ForceMultiLine = true
+	}
+	merged := terms[0]
+	for _, t := range terms[1:] {
+		merged = &bzl.BinaryExpr{X: merged, Op: "+", Y: t}
+	}
+	return merged, nil
+}
+
+// sumTerms returns the lists and calls to select that are concatenated
+// with + in "expr", in order. Parentheses are removed. An error is
+// returned if any other kind of expression is found.
+func sumTerms(expr bzl.Expr) ([]bzl.Expr, error) {
+	switch e := expr.(type) {
+	case nil:
+		return nil, nil
 	case *bzl.ListExpr:
-		return expr, nil, nil
+		return []bzl.Expr{e}, nil
 	case *bzl.CallExpr:
-		if x, ok := expr.X.(*bzl.LiteralExpr); ok && x.Token == "select" && len(expr.List) == 1 {
-			if d, ok := expr.List[0].(*bzl.DictExpr); ok {
-				return nil, d, nil
-			}
+		if selectDict(e) == nil {
+			return nil, fmt.Errorf("expression could not be matched: call is not a select with a dict")
 		}
+		return []bzl.Expr{e}, nil
+	case *bzl.ParenExpr:
+		return sumTerms(e.X)
 	case *bzl.BinaryExpr:
-		if expr.Op != "+" {
-			return nil, nil, fmt.Errorf("expression could not be matched: unknown operator: %s", expr.Op)
+		if e.Op != "+" {
+			return nil, fmt.Errorf("expression could not be matched: unknown operator: %s", e.Op)
 		}
-		l, ok := expr.X.(*bzl.ListExpr)
-		if !ok {
-			return nil, nil, fmt.Errorf("expression could not be matched: left operand not a list")
+		x, err := sumTerms(e.X)
+		if err != nil {
+			return nil, err
 		}
-		call, ok := expr.Y.(*bzl.CallExpr)
-		if !ok || len(call.List) != 1 {
-			return nil, nil, fmt.Errorf("expression could not be matched: right operand not a call with one argument")
+		y, err := sumTerms(e.Y)
+		if err != nil {
+			return nil, err
 		}
-		x, ok := call.X.(*bzl.LiteralExpr)
-		if !ok || x.Token != "select" {
-			return nil, nil, fmt.Errorf("expression could not be matched: right operand not a call to select")
+		return append(x, y...), nil
+	}
+	return nil, fmt.Errorf("expression could not be matched")
+}
+
+// selectDict returns the dict argument of "expr" if it is a call to select
+// with a dict, or nil otherwise.
+func selectDict(expr bzl.Expr) *bzl.DictExpr {
+	call, ok := expr.(*bzl.CallExpr)
+	if !ok || len(call.List) != 1 {
+		return nil
+	}
+	if x, ok := call.X.(*bzl.LiteralExpr); !ok || x.Token != "select" {
+		return nil
+	}
+	d, _ := call.List[0].(*bzl.DictExpr)
+	return d
+}
+
+const (
+	// platformPrefix is the prefix of the config_setting labels Gazelle
+	// uses as keys in generated selects.
+	platformPrefix = "@io_bazel_rules_go//go/platform:"
+
+	defaultCondition = "//conditions:default"
+)
+
+// isGeneratedKey returns whether "key" is a condition Gazelle generates
+// cases for in selects, either for a platform or because it appears in the
+// generated dict "gen". Labels of other config_settings are conditions
+// written by hand.
+func isGeneratedKey(key string, gen *bzl.DictExpr) bool {
+	if strings.HasPrefix(key, platformPrefix) {
+		return true
+	}
+	if !strings.Contains(key, "//") && !strings.HasPrefix(key, ":") {
+		return true
+	}
+	if gen != nil {
+		for _, kv := range gen.List {
+			if k, _, err := dictEntryKeyValue(kv); err == nil && k == key && k != defaultCondition {
+				return true
+			}
 		}
-		d, ok := call.List[0].(*bzl.DictExpr)
+	}
+	return false
+}
+
+// isGeneratedSelect returns whether the select dict "d" was generated by
+// Gazelle. This is true if it has a case for a generated condition, or if
+// its only case is the default.
+func isGeneratedSelect(d *bzl.DictExpr, gen *bzl.DictExpr) bool {
+	if d == nil {
+		return false
+	}
+	onlyDefault := true
+	for _, kv := range d.List {
+		k, ok := dictKey(kv)
 		if !ok {
-			return nil, nil, fmt.Errorf("expression could not be matched: argument to right operand not a dict")
+			return false
+		}
+		if isGeneratedKey(k, gen) {
+			return true
 		}
-		return l, d, nil
+		onlyDefault = onlyDefault && k == defaultCondition
 	}
-	return nil, nil, fmt.Errorf("expression could not be matched")
+	return onlyDefault
+}
+
+// dictKey returns the key of the dict entry "e" if it is a string.
+func dictKey(e bzl.Expr) (string, bool) {
+	kv, ok := e.(*bzl.KeyValueExpr)
+	if !ok {
+		return "", false
+	}
+	k, ok := kv.Key.(*bzl.StringExpr)
+	if !ok {
+		return "", false
+	}
+	return k.Value, true
+}
+
+// withoutStrings returns a copy of "list" without the strings in "remove".
+func withoutStrings(list *bzl.ListExpr, remove map[string]bool) *bzl.ListExpr {
+	if list == nil || len(remove) == 0 {
+		return list
+	}
+	result := *list
+	result.List = nil
+	for _, v := range list.List {
+		if !remove[stringValue(v)] {
+			result.List = append(result.List, v)
+		}
+	}
+	return &result
+}
+
+// exprListAndDict matches an expression in the form Gazelle generates and
+// attempts to extract either a list of expressions, a call to select with
+// a dictionary, or both. An error is returned if the expression could not
+// be matched.
+func exprListAndDict(expr bzl.Expr) (*bzl.ListExpr, *bzl.DictExpr, error) {
+	terms, err := sumTerms(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	var list *bzl.ListExpr
+	var dict *bzl.DictExpr
+	for _, t := range terms {
+		switch t := t.(type) {
+		case *bzl.ListExpr:
+			if list != nil {
+				return nil, nil, fmt.Errorf("expression could not be matched: more than one list")
+			}
+			list = t
+		default:
+			if dict != nil {
+				return nil, nil, fmt.Errorf("expression could not be matched: more than one select")
+			}
+			dict = selectDict(t)
+		}
+	}
+	return list, dict, nil
 }
 
 func mergeList(gen, old *bzl.ListExpr) *bzl.ListExpr {
@@ -273,18 +615,72 @@ func mergeList(gen, old *bzl.ListExpr) *bzl.ListExpr {
 	return &bzl.ListExpr{List: merged}
 }
 
+// unionList returns a list containing the elements of "old" followed by
+// elements of "gen" that are not in "old". Elements of "old" in "owned" are
+// only kept if they are in "gen" or are marked with "# keep". "gen" may be
+// nil if nothing was generated. "old" is returned unchanged if either
+// expression is not a list. nil is returned if the merged list is empty.
+func unionList(gen, old bzl.Expr, owned map[string]bool) bzl.Expr {
+	var genElems []bzl.Expr
+	if gen != nil {
+		genList, ok := gen.(*bzl.ListExpr)
+		if !ok {
+			return old
+		}
+		genElems = genList.List
+	}
+	oldList, ok := old.(*bzl.ListExpr)
+	if !ok {
+		return old
+	}
+	inGen := make(map[string]bool)
+	for _, v := range genElems {
+		inGen[stringValue(v)] = true
+	}
+	have := make(map[string]bool)
+	union := *oldList
+	union.List = nil
+	for _, v := range oldList.List {
+		s := stringValue(v)
+		if owned[s] && !inGen[s] && !shouldKeep(v) {
+			continue
+		}
+		union.List = append(union.List, v)
+		have[s] = true
+	}
+	for _, v := range genElems {
+		if s := stringValue(v); !have[s] {
+			union.List = append(union.List, v)
+			have[s] = true
+		}
+	}
+	if len(union.List) == 0 {
+		return nil
+	}
+	return &union
+}
+
+// mergeDict merges the generated select dict "gen" with "old". Cases for
+// conditions Gazelle generates are merged like lists. Cases for other
+// conditions were written by hand, so they are preserved as they are.
 func mergeDict(gen, old *bzl.DictExpr) (*bzl.DictExpr, error) {
 	if old == nil {
 		return gen, nil
 	}
-	if gen == nil {
-		gen = &bzl.DictExpr{List: []bzl.Expr{}}
-	}
 
 	var entries []*dictEntry
 	entryMap := make(map[string]*dictEntry)
 
 	for _, kv := range old.List {
+		if k, ok := dictKey(kv); ok && k != defaultCondition && !isGeneratedKey(k, gen) {
+			if _, ok := entryMap[k]; ok {
+				return nil, fmt.Errorf("old dict contains more than one case named %q", k)
+			}
+			e := &dictEntry{key: k, user: kv}
+			entries = append(entries, e)
+			entryMap[k] = e
+			continue
+		}
 		k, v, err := dictEntryKeyValue(kv)
 		if err != nil {
 			return nil, err
@@ -297,6 +693,9 @@ func mergeDict(gen, old *bzl.DictExpr) (*bzl.DictExpr, error) {
 		entryMap[k] = e
 	}
 
+	if gen == nil {
+		gen = &bzl.DictExpr{List: []bzl.Expr{}}
+	}
 	for _, kv := range gen.List {
 		k, v, err := dictEntryKeyValue(kv)
 		if err != nil {
@@ -313,9 +712,15 @@ func mergeDict(gen, old *bzl.DictExpr) (*bzl.DictExpr, error) {
 
 	keys := make([]string, 0, len(entries))
 	haveDefault := false
+	haveUser := false
 	for _, e := range entries {
+		if e.user != nil {
+			haveUser = true
+			keys = append(keys, e.key)
+			continue
+		}
 		e.mergedValue = mergeList(e.genValue, e.oldValue)
-		if e.key == "//conditions:default" {
+		if e.key == defaultCondition {
 			// Keep the default case, even if it's empty.
 			haveDefault = true
 			if e.mergedValue == nil {
@@ -325,18 +730,28 @@ func mergeDict(gen, old *bzl.DictExpr) (*bzl.DictExpr, error) {
 			keys = append(keys, e.key)
 		}
 	}
-	if len(keys) == 0 && (!haveDefault || len(entryMap["//conditions:default"].mergedValue.List) == 0) {
+	if len(keys) == 0 && (!haveDefault || len(entryMap[defaultCondition].mergedValue.List) == 0) {
 		return nil, nil
 	}
+	if haveUser && !haveDefault {
+		// Without a default case, a select fails to match on platforms that
+		// Gazelle removed cases for.
+		haveDefault = true
+		entryMap[defaultCondition] = &dictEntry{key: defaultCondition, mergedValue: &bzl.ListExpr{}}
+	}
 	sort.Strings(keys)
 	// Always put the default case last.
 	if haveDefault {
-		keys = append(keys, "//conditions:default")
+		keys = append(keys, defaultCondition)
 	}
 
 	mergedEntries := make([]bzl.Expr, len(keys))
 	for i, k := range keys {
 		e := entryMap[k]
+		if e.user != nil {
+			mergedEntries[i] = e.user
+			continue
+		}
 		mergedEntries[i] = &bzl.KeyValueExpr{
 			Key:   &bzl.StringExpr{Value: e.key},
 			Value: e.mergedValue,
@@ -349,6 +764,10 @@ func mergeDict(gen, old *bzl.DictExpr) (*bzl.DictExpr, error) {
 type dictEntry struct {
 	key                             string
 	oldValue, genValue, mergedValue *bzl.ListExpr
+
+	// user is the entry for a condition Gazelle doesn't generate. It is
+	// preserved as is.
+	user bzl.Expr
 }
 
 func dictEntryKeyValue(e bzl.Expr) (string, *bzl.ListExpr, error) {
@@ -367,31 +786,6 @@ func dictEntryKeyValue(e bzl.Expr) (string, *bzl.ListExpr, error) {
 	return k.Value, v, nil
 }
 
-func mergeLoad(gen, old *bzl.CallExpr, oldfile *bzl.File) *bzl.CallExpr {
-	vals := make(map[string]bzl.Expr)
-	for _, v := range gen.List[1:] {
-		vals[stringValue(v)] = v
-	}
-	for _, v := range old.List[1:] {
-		rule := stringValue(v)
-		if _, ok := vals[rule]; !ok && ruleUsed(rule, oldfile) {
-			vals[rule] = v
-		}
-	}
-	keys := make([]string, 0, len(vals))
-	for k := range vals {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	merged := *old
-	merged.List = old.List[:1]
-	for _, k := range keys {
-		merged.List = append(merged.List, vals[k])
-	}
-	return &merged
-}
-
 // shouldIgnore checks whether "gazelle:ignore" appears at the beginning of
 // a comment before or after any top-level statement in the file.
 func shouldIgnore(oldFile *bzl.File) bool {
@@ -417,15 +811,11 @@ func shouldKeep(e bzl.Expr) bool {
 	return len(c.Suffix) > 0 && strings.HasPrefix(c.Suffix[0].Token, keep)
 }
 
-func ruleUsed(rule string, oldfile *bzl.File) bool {
-	return len(oldfile.Rules(rule)) != 0
-}
-
 // match looks for the matching CallExpr in f using X and name
 // i.e. two 'go_library(name = "foo", ...)' are considered matches
 // despite the values of the other fields.
 // exception: if c is a 'load' statement, the match is done on the first value.
-func match(f *bzl.File, c *bzl.CallExpr) (int, *bzl.CallExpr) {
+func match(f *bzl.File, c *bzl.CallExpr, kinds map[string]string) (int, *bzl.CallExpr) {
 	var m matcher
 	if kind := kind(c); kind == "load" {
 		if len(c.List) == 0 {
@@ -433,7 +823,7 @@ func match(f *bzl.File, c *bzl.CallExpr) (int, *bzl.CallExpr) {
 		}
 		m = &loadMatcher{stringValue(c.List[0])}
 	} else {
-		m = &nameMatcher{kind, name(c)}
+		m = &nameMatcher{kind, name(c), kinds}
 	}
 	for i, s := range f.Stmt {
 		other, ok := s.(*bzl.CallExpr)
@@ -453,10 +843,17 @@ type matcher interface {
 
 type nameMatcher struct {
 	kind, name string
+
+	// kinds maps rule kinds to wrapper kinds. A rule of either kind matches.
+	kinds map[string]string
 }
 
 func (m *nameMatcher) match(c *bzl.CallExpr) bool {
-	return m.kind == kind(c) && m.name == name(c)
+	if m.name != name(c) {
+		return false
+	}
+	k := kind(c)
+	return m.kind == k || m.kinds[k] == m.kind || m.kinds[m.kind] == k
 }
 
 type loadMatcher struct {
@@ -422,6 +422,217 @@ go_library(
     # merged attr
     srcs = ["foo.go"],
 )
+`,
+	}, {
+		desc: "merge test attributes",
+		previous: `
+load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    size = "large",
+    srcs = ["foo_test.go"],
+    tags = [
+        "exclusive",
+        "manual",
+    ],
+)
+
+go_test(
+    name = "go_default_xtest",
+    size = "large",  # keep
+    srcs = ["foo_x_test.go"],
+    tags = [
+        "manual",
+        "exclusive",
+    ],
+)
+`,
+		current: `
+load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    size = "medium",
+    timeout = "long",
+    srcs = ["foo_test.go"],
+    shard_count = 4,
+    tags = [
+        "manual",
+        "slow",
+    ],
+)
+
+go_test(
+    name = "go_default_xtest",
+    size = "medium",
+    srcs = ["foo_x_test.go"],
+    tags = ["slow"],
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    size = "medium",
+    srcs = ["foo_test.go"],
+    tags = [
+        "exclusive",
+        "manual",
+        "slow",
+    ],
+    timeout = "long",
+    shard_count = 4,
+)
+
+go_test(
+    name = "go_default_xtest",
+    size = "large",  # keep
+    srcs = ["foo_x_test.go"],
+    tags = [
+        "exclusive",
+        "slow",
+    ],
+)
+`,
+	}, {
+		desc: "merge concatenated lists and selects",
+		previous: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "foo.go",
+        "old.go",
+    ] + [
+        "extra.go",
+    ] + select({
+        ":debug": ["debug.go"],
+        "//conditions:default": [],
+    }) + select({
+        "@io_bazel_rules_go//go/platform:linux_amd64": ["foo_linux.go"],
+        "@io_bazel_rules_go//go/platform:windows_amd64": ["foo_windows.go"],
+        "//conditions:default": [],
+    }),
+)
+`,
+		current: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "extra.go",
+        "foo.go",
+        "new.go",
+    ] + select({
+        "@io_bazel_rules_go//go/platform:darwin_amd64": ["foo_darwin.go"],
+        "@io_bazel_rules_go//go/platform:linux_amd64": ["foo_linux.go"],
+        "//conditions:default": [],
+    }),
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "foo.go",
+        "new.go",
+    ] + [
+        "extra.go",
+    ] + select({
+        ":debug": ["debug.go"],
+        "//conditions:default": [],
+    }) + select({
+        "@io_bazel_rules_go//go/platform:darwin_amd64": ["foo_darwin.go"],
+        "@io_bazel_rules_go//go/platform:linux_amd64": ["foo_linux.go"],
+        "//conditions:default": [],
+    }),
+)
+`,
+	}, {
+		desc: "merge select with custom conditions",
+		previous: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+    deps = select({
+        "//config:race": DEPS_RACE,
+        "@io_bazel_rules_go//go/platform:linux_amd64": ["//linux:go_default_library"],
+        "@io_bazel_rules_go//go/platform:windows_amd64": ["//windows:go_default_library"],
+    }),
+)
+`,
+		current: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+    deps = select({
+        "@io_bazel_rules_go//go/platform:darwin_amd64": ["//darwin:go_default_library"],
+        "@io_bazel_rules_go//go/platform:linux_amd64": ["//linux:go_default_library"],
+        "//conditions:default": [],
+    }),
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+    deps = select({
+        "//config:race": DEPS_RACE,
+        "@io_bazel_rules_go//go/platform:darwin_amd64": ["//darwin:go_default_library"],
+        "@io_bazel_rules_go//go/platform:linux_amd64": ["//linux:go_default_library"],
+        "//conditions:default": [],
+    }),
+)
+`,
+	}, {
+		desc: "keep select with only custom conditions",
+		previous: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"] + select({
+        ":debug": ["debug.go"],
+        "//conditions:default": ["release.go"],
+    }),
+)
+`,
+		current: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "bar.go",
+        "foo.go",
+    ],
+)
+`,
+		expected: `
+load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "bar.go",
+        "foo.go",
+    ] + select({
+        ":debug": ["debug.go"],
+        "//conditions:default": ["release.go"],
+    }),
+)
 `,
 	},
 }
@@ -469,3 +680,396 @@ func TestMergeWithExistingDifferentName(t *testing.T) {
 		t.Errorf("got %q; want %q", got, want)
 	}
 }
+
+func TestMergeWithBase(t *testing.T) {
+	for _, tc := range []struct {
+		desc, base, previous, current, expected string
+	}{
+		{
+			desc: "manual changes kept",
+			base: `
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+    importpath = "example.com/foo",
+    deps = [
+        "//bar:go_default_library",
+        "//baz:go_default_library",
+    ],
+)
+`,
+			previous: `
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "foo.go",
+        "extra.go",
+    ],
+    importpath = "example.com/foo",
+    deps = ["//bar:go_default_library"],
+)
+`,
+			current: `
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "foo.go",
+        "new.go",
+    ],
+    importpath = "example.com/foo/v2",
+    deps = [
+        "//bar:go_default_library",
+        "//baz:go_default_library",
+    ],
+)
+`,
+			expected: `
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "foo.go",
+        "extra.go",
+        "new.go",
+    ],
+    importpath = "example.com/foo/v2",
+    deps = ["//bar:go_default_library"],
+)
+`,
+		}, {
+			desc: "generated removals applied",
+			base: `
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "foo.go",
+        "old.go",
+    ] + select({
+        "@io_bazel_rules_go//go/platform:linux_amd64": ["foo_linux.go"],
+        "//conditions:default": [],
+    }),
+)
+`,
+			previous: `
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "foo.go",
+        "old.go",
+        "extra.go",
+    ] + select({
+        "@io_bazel_rules_go//go/platform:linux_amd64": ["foo_linux.go"],
+        "//conditions:default": [],
+    }),
+)
+`,
+			current: `
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+)
+`,
+			expected: `
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "foo.go",
+        "extra.go",
+    ],
+)
+`,
+		}, {
+			desc: "rules deleted",
+			base: `
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+)
+
+go_test(
+    name = "go_default_xtest",
+    srcs = ["foo_x_test.go"],
+)
+
+go_binary(
+    name = "foo",
+    library = ":go_default_library",
+)
+`,
+			previous: `
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+)
+
+go_test(
+    name = "go_default_xtest",
+    srcs = ["foo_x_test.go"],
+    data = ["testdata"],
+)
+`,
+			current: `
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+)
+
+go_binary(
+    name = "foo",
+    library = ":go_default_library",
+)
+`,
+			expected: `
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+)
+
+go_test(
+    name = "go_default_xtest",
+    srcs = ["foo_x_test.go"],
+    data = ["testdata"],
+)
+`,
+		}, {
+			desc: "two-way fallback",
+			base: `
+go_library(
+    name = "go_default_library",
+    deps = [
+        "//bar:go_default_library",
+        "//baz:go_default_library",
+    ],
+)
+`,
+			previous: `
+go_library(
+    name = "go_default_library",
+    deps = [
+        "//bar:go_default_library",
+        "//baz:go_default_library",
+    ] + ["//manual:go_default_library"],
+)
+`,
+			current: `
+go_library(
+    name = "go_default_library",
+    deps = [
+        "//bar:go_default_library",
+        "//qux:go_default_library",
+    ],
+)
+`,
+			expected: `
+go_library(
+    name = "go_default_library",
+    deps = [
+        "//bar:go_default_library",
+        "//qux:go_default_library",
+    ] + ["//manual:go_default_library"],
+)
+`,
+		}, {
+			desc: "conflict",
+			base: `
+go_test(
+    name = "go_default_test",
+    size = "small",
+)
+`,
+			previous: `
+go_test(
+    name = "go_default_test",
+    size = "large",
+)
+`,
+			current: `
+go_test(
+    name = "go_default_test",
+    size = "medium",
+    timeout = "long",
+)
+`,
+			expected: `
+go_test(
+    name = "go_default_test",
+    size = "large",
+    timeout = "long",
+)
+`,
+		},
+	} {
+		baseFile, err := bzl.Parse("base", []byte(tc.base))
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		genFile, err := bzl.Parse("current", []byte(tc.current))
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		oldFile, err := bzl.Parse("previous", []byte(tc.previous))
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		mergedFile := MergeWithBase(genFile, baseFile, oldFile, Options{})
+		want := tc.expected[1:]
+		if got := string(bzl.Format(mergedFile)); got != want {
+			t.Errorf("%s: got %s; want %s", tc.desc, got, want)
+		}
+	}
+}
+
+func TestMergeWithKinds(t *testing.T) {
+	old := `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+    library = ":go_default_library",
+)
+`
+	gen := `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+load("//tools:go.bzl", "my_go_library")
+
+my_go_library(
+    name = "go_default_library",
+    srcs = [
+        "bar.go",
+        "foo.go",
+    ],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+    library = ":go_default_library",
+)
+`
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+load("//tools:go.bzl", "my_go_library")
+
+my_go_library(
+    name = "go_default_library",
+    srcs = [
+        "bar.go",
+        "foo.go",
+    ],
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+    library = ":go_default_library",
+)
+`
+	oldFile, err := bzl.Parse("old", []byte(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	genFile, err := bzl.Parse("gen", []byte(gen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mergedFile := MergeWithBase(genFile, nil, oldFile, Options{Kinds: map[string]string{"go_library": "my_go_library"}})
+	if got := string(bzl.Format(mergedFile)); got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestMergeLoads(t *testing.T) {
+	old := `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_proto_library", go_lib = "go_library")
+load("//tools:lint.bzl", "lint")
+
+go_lib(
+    name = "go_default_library",
+    srcs = ["foo.go"],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    proto = ":foo_proto",
+)
+
+lint(name = "lint")
+`
+	gen := `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "bar.go",
+        "foo.go",
+    ],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+)
+`
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_test", go_lib = "go_library")
+load("//tools:lint.bzl", "lint")
+load("@io_bazel_rules_go//proto:def.bzl", "go_proto_library")
+
+go_lib(
+    name = "go_default_library",
+    srcs = [
+        "bar.go",
+        "foo.go",
+    ],
+)
+
+go_proto_library(
+    name = "foo_go_proto",
+    proto = ":foo_proto",
+)
+
+lint(name = "lint")
+
+go_test(
+    name = "go_default_test",
+    srcs = ["foo_test.go"],
+)
+`
+	oldFile, err := bzl.Parse("old", []byte(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldFormatted := string(bzl.Format(oldFile))
+	genFile, err := bzl.Parse("gen", []byte(gen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{
+		Loads: []Load{
+			{Name: "@io_bazel_rules_go//go:def.bzl", Kinds: []string{"go_binary", "go_library", "go_test"}},
+			{Name: "@io_bazel_rules_go//proto:def.bzl", Kinds: []string{"go_proto_library"}},
+		},
+	}
+	mergedFile := MergeWithBase(genFile, nil, oldFile, opts)
+	if got := string(bzl.Format(mergedFile)); got != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+	if got := string(bzl.Format(oldFile)); got != oldFormatted {
+		t.Errorf("old file was modified: got %s; want %s", got, oldFormatted)
+	}
+}
@@ -0,0 +1,341 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package merger
+
+import (
+	"fmt"
+	"sort"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// mergeAttr3 performs a three-way merge of the values of the attribute
+// "key". "gen" is the generated value, "base" is the value Gazelle
+// generated previously, and "old" is the existing value. "gen" is nil if
+// the attribute is no longer generated; the others must not be nil.
+//
+// The merged value is returned, or nil if the attribute should be deleted.
+// false is returned if the values conflict.
+func mergeAttr3(key string, gen, base, old bzl.Expr) (bzl.Expr, bool) {
+	if equalExpr(old, base) {
+		return gen, true
+	}
+	if gen != nil && (equalExpr(gen, base) || equalExpr(gen, old)) {
+		return old, true
+	}
+	if !mergeableFields[key] && !unionFields[key] {
+		return nil, false
+	}
+	merged, err := mergeExpr3(gen, base, old)
+	if err != nil {
+		// The expressions aren't in the form Gazelle generates, for example
+		// because extra lists were added by hand. Fall back to the two-way
+		// merge, which handles more forms.
+		if unionFields[key] {
+			return unionList(gen, old, ownedValues[key]), true
+		}
+		merged, err = mergeExpr(gen, old)
+		if err != nil {
+			return nil, false
+		}
+	}
+	return merged, true
+}
+
+// mergeExpr3 performs a three-way merge of list or select expressions in
+// the form Gazelle generates (see exprListAndDict). Strings added to "old"
+// are kept, strings removed from "old" are not added again, and other
+// strings are updated to match "gen". An error is returned if any of the
+// expressions is in a different form.
+func mergeExpr3(gen, base, old bzl.Expr) (bzl.Expr, error) {
+	genList, genDict, err := exprListAndDict(gen)
+	if err != nil {
+		return nil, err
+	}
+	baseList, baseDict, err := exprListAndDict(base)
+	if err != nil {
+		return nil, err
+	}
+	oldList, oldDict, err := exprListAndDict(old)
+	if err != nil {
+		return nil, err
+	}
+
+	mergedList := mergeList3(genList, baseList, oldList)
+	mergedDict, err := mergeDict3(genDict, baseDict, oldDict)
+	if err != nil {
+		return nil, err
+	}
+	if mergedDict == nil {
+		if mergedList == nil {
+			return nil, nil
+		}
+		return mergedList, nil
+	}
+	mergedSelect := &bzl.CallExpr{
+		X:    &bzl.LiteralExpr{Token: "select"},
+		List: []bzl.Expr{mergedDict},
+	}
+	if mergedList == nil {
+		return mergedSelect, nil
+	}
+	mergedList.ForceMultiLine = true
+	return &bzl.BinaryExpr{X: mergedList, Op: "+", Y: mergedSelect}, nil
+}
+
+// mergeList3 performs a three-way merge of lists. Any of the lists may be
+// nil. nil is returned if the merged list is empty.
+func mergeList3(gen, base, old *bzl.ListExpr) *bzl.ListExpr {
+	inGen := listKeys(gen)
+	inBase := listKeys(base)
+
+	var merged []bzl.Expr
+	have := make(map[string]bool)
+	if old != nil {
+		for _, v := range old.List {
+			k := elemKey(v)
+			if shouldKeep(v) || inGen[k] || !inBase[k] {
+				merged = append(merged, v)
+				have[k] = true
+			}
+		}
+	}
+	if gen != nil {
+		for _, v := range gen.List {
+			if k := elemKey(v); !have[k] && !inBase[k] {
+				merged = append(merged, v)
+				have[k] = true
+			}
+		}
+	}
+
+	if len(merged) == 0 {
+		return nil
+	}
+	return &bzl.ListExpr{List: merged}
+}
+
+// mergeDict3 performs a three-way merge of select dicts. Cases are merged
+// with mergeList3. Cases that were added by hand are preserved as they are,
+// and cases that were deleted by hand are not added again.
+func mergeDict3(gen, base, old *bzl.DictExpr) (*bzl.DictExpr, error) {
+	genEntries, genKeys, err := dictEntries(gen)
+	if err != nil {
+		return nil, err
+	}
+	baseEntries, _, err := dictEntries(base)
+	if err != nil {
+		return nil, err
+	}
+	oldEntries, oldKeys, err := dictEntries(old)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]bzl.Expr)
+	for _, k := range append(oldKeys, genKeys...) {
+		if _, ok := merged[k]; ok {
+			continue
+		}
+		o, b, g := oldEntries[k], baseEntries[k], genEntries[k]
+		if o != nil && b == nil && g == nil {
+			// The case was added by hand.
+			merged[k] = o
+			continue
+		}
+		if o == nil && b != nil {
+			// The case was deleted by hand.
+			continue
+		}
+		var lists [3]*bzl.ListExpr
+		for i, kv := range []*bzl.KeyValueExpr{g, b, o} {
+			if kv == nil {
+				continue
+			}
+			l, ok := kv.Value.(*bzl.ListExpr)
+			if !ok {
+				return nil, fmt.Errorf("dict value was not list: %#v", kv.Value)
+			}
+			lists[i] = l
+		}
+		v := mergeList3(lists[0], lists[1], lists[2])
+		if v == nil {
+			if k != defaultCondition {
+				continue
+			}
+			// Keep the default case, even if it's empty.
+			v = &bzl.ListExpr{}
+		}
+		merged[k] = &bzl.KeyValueExpr{Key: &bzl.StringExpr{Value: k}, Value: v}
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		if k != defaultCondition {
+			keys = append(keys, k)
+		}
+	}
+	def, haveDefault := merged[defaultCondition]
+	if len(keys) == 0 && (!haveDefault || len(def.(*bzl.KeyValueExpr).Value.(*bzl.ListExpr).List) == 0) {
+		return nil, nil
+	}
+	sort.Strings(keys)
+	// Always put the default case last.
+	if haveDefault {
+		keys = append(keys, defaultCondition)
+	}
+
+	mergedEntries := make([]bzl.Expr, len(keys))
+	for i, k := range keys {
+		mergedEntries[i] = merged[k]
+	}
+	return &bzl.DictExpr{List: mergedEntries, ForceMultiLine: true}, nil
+}
+
+// dictEntries returns the entries of "d" indexed by key and a list of keys
+// in order. "d" may be nil. An error is returned if a key is not a string.
+func dictEntries(d *bzl.DictExpr) (map[string]*bzl.KeyValueExpr, []string, error) {
+	entries := make(map[string]*bzl.KeyValueExpr)
+	if d == nil {
+		return entries, nil, nil
+	}
+	var keys []string
+	for _, e := range d.List {
+		k, ok := dictKey(e)
+		if !ok {
+			return nil, nil, fmt.Errorf("dict entry was not a key-value pair with a string key: %#v", e)
+		}
+		if _, ok := entries[k]; ok {
+			return nil, nil, fmt.Errorf("dict contains more than one case named %q", k)
+		}
+		entries[k] = e.(*bzl.KeyValueExpr)
+		keys = append(keys, k)
+	}
+	return entries, keys, nil
+}
+
+// listKeys returns the set of keys of elements of "l", which may be nil.
+func listKeys(l *bzl.ListExpr) map[string]bool {
+	keys := make(map[string]bool)
+	if l != nil {
+		for _, v := range l.List {
+			keys[elemKey(v)] = true
+		}
+	}
+	return keys
+}
+
+// elemKey returns a string that identifies a list element: the value of a
+// string, or the formatted expression otherwise.
+func elemKey(e bzl.Expr) string {
+	if s, ok := e.(*bzl.StringExpr); ok {
+		return s.Value
+	}
+	return bzl.FormatString(e)
+}
+
+// equalRule returns whether "x" and "y" are calls to the same function
+// with the same arguments. Unlike equalExpr, the order of named arguments
+// is ignored.
+func equalRule(x, y *bzl.CallExpr) bool {
+	if !equalExpr(x.X, y.X) || len(x.List) != len(y.List) {
+		return false
+	}
+	var xArgs, yArgs []bzl.Expr
+	for _, a := range x.List {
+		if b, ok := a.(*bzl.BinaryExpr); !ok || b.Op != "=" {
+			xArgs = append(xArgs, a)
+		}
+	}
+	for _, a := range y.List {
+		if b, ok := a.(*bzl.BinaryExpr); !ok || b.Op != "=" {
+			yArgs = append(yArgs, a)
+		}
+	}
+	if !equalExprs(xArgs, yArgs) {
+		return false
+	}
+	xRule, yRule := bzl.Rule{Call: x}, bzl.Rule{Call: y}
+	for _, k := range xRule.AttrKeys() {
+		if !equalExpr(xRule.Attr(k), yRule.Attr(k)) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalExpr returns whether "x" and "y" are the same expression. Positions
+// and formatting are ignored, but comments on elements are compared, since
+// they may be "# keep" markers.
+func equalExpr(x, y bzl.Expr) bool {
+	if x == nil || y == nil {
+		return x == nil && y == nil
+	}
+	if !equalComments(x.Comment().Suffix, y.Comment().Suffix) {
+		return false
+	}
+	switch x := x.(type) {
+	case *bzl.StringExpr:
+		y, ok := y.(*bzl.StringExpr)
+		return ok && x.Value == y.Value
+	case *bzl.LiteralExpr:
+		y, ok := y.(*bzl.LiteralExpr)
+		return ok && x.Token == y.Token
+	case *bzl.ListExpr:
+		y, ok := y.(*bzl.ListExpr)
+		return ok && equalExprs(x.List, y.List)
+	case *bzl.DictExpr:
+		y, ok := y.(*bzl.DictExpr)
+		return ok && equalExprs(x.List, y.List)
+	case *bzl.KeyValueExpr:
+		y, ok := y.(*bzl.KeyValueExpr)
+		return ok && equalExpr(x.Key, y.Key) && equalExpr(x.Value, y.Value)
+	case *bzl.CallExpr:
+		y, ok := y.(*bzl.CallExpr)
+		return ok && equalExpr(x.X, y.X) && equalExprs(x.List, y.List)
+	case *bzl.BinaryExpr:
+		y, ok := y.(*bzl.BinaryExpr)
+		return ok && x.Op == y.Op && equalExpr(x.X, y.X) && equalExpr(x.Y, y.Y)
+	case *bzl.ParenExpr:
+		y, ok := y.(*bzl.ParenExpr)
+		return ok && equalExpr(x.X, y.X)
+	}
+	return bzl.FormatString(x) == bzl.FormatString(y)
+}
+
+func equalExprs(xs, ys []bzl.Expr) bool {
+	if len(xs) != len(ys) {
+		return false
+	}
+	for i := range xs {
+		if !equalExpr(xs[i], ys[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalComments(xs, ys []bzl.Comment) bool {
+	if len(xs) != len(ys) {
+		return false
+	}
+	for i := range xs {
+		if xs[i].Token != ys[i].Token {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+const (
+	// bazelIgnoreFileName is the name of a file in the repository root which
+	// lists directories Bazel should not treat as part of the workspace.
+	// Each line is a path relative to the repository root.
+	bazelIgnoreFileName = ".bazelignore"
+
+	// gazelleIgnoreFileName is the name of a file in the repository root
+	// which lists patterns for files and directories Gazelle should skip.
+	// Each line is a pattern in the same format as config.Config.Excludes.
+	gazelleIgnoreFileName = ".gazelleignore"
+)
+
+// excludeMatcher determines whether files and directories should be skipped
+// by Walk.
+type excludeMatcher struct {
+	// paths is a set of slash-separated paths relative to the repository
+	// root. Files and directories with these paths are excluded.
+	paths map[string]bool
+
+	// patterns is a list of patterns, as described in config.Config.Excludes.
+	patterns []string
+}
+
+// newExcludeMatcher builds an excludeMatcher from c.Excludes and the ignore
+// files in c.RepoRoot. Errors reading ignore files are logged.
+func newExcludeMatcher(c *config.Config) *excludeMatcher {
+	m := &excludeMatcher{paths: make(map[string]bool)}
+	for _, p := range c.Excludes {
+		m.addPattern(p)
+	}
+
+	bazelIgnore, err := readIgnoreFile(filepath.Join(c.RepoRoot, bazelIgnoreFileName))
+	if err != nil && !os.IsNotExist(err) {
+		log.Print(err)
+	}
+	for _, p := range bazelIgnore {
+		m.paths[path.Clean(strings.TrimSuffix(p, "/"))] = true
+	}
+
+	gazelleIgnore, err := readIgnoreFile(filepath.Join(c.RepoRoot, gazelleIgnoreFileName))
+	if err != nil && !os.IsNotExist(err) {
+		log.Print(err)
+	}
+	for _, p := range gazelleIgnore {
+		m.addPattern(p)
+	}
+	return m
+}
+
+func (m *excludeMatcher) addPattern(p string) {
+	p = strings.TrimSuffix(filepath.ToSlash(p), "/")
+	if _, err := path.Match(p, ""); err != nil {
+		log.Printf("invalid exclude pattern %q: %v", p, err)
+		return
+	}
+	m.patterns = append(m.patterns, p)
+}
+
+// match returns whether the file or directory with the slash-separated
+// path "rel" (relative to the repository root) should be skipped.
+func (m *excludeMatcher) match(rel string) bool {
+	if rel == "" {
+		return false
+	}
+	if m.paths[rel] {
+		return true
+	}
+	base := path.Base(rel)
+	for _, p := range m.patterns {
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+		if !strings.Contains(p, "/") {
+			if ok, _ := path.Match(p, base); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readIgnoreFile reads a list of paths or patterns from a file. Blank lines
+// and lines starting with "#" are skipped.
+func readIgnoreFile(p string) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
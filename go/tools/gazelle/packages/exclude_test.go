@@ -0,0 +1,48 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import "testing"
+
+func TestExcludeMatcher(t *testing.T) {
+	m := &excludeMatcher{
+		paths: map[string]bool{"third_party/node": true},
+	}
+	for _, p := range []string{"node_modules", "*.pb.go", "docs/*", "[bad"} {
+		m.addPattern(p)
+	}
+	for _, tc := range []struct {
+		rel  string
+		want bool
+	}{
+		{"", false},
+		{"node_modules", true},
+		{"web/node_modules", true},
+		{"web/node_modules_extra", false},
+		{"third_party/node", true},
+		{"node", false},
+		{"foo.pb.go", true},
+		{"a/b/foo.pb.go", true},
+		{"docs/site", true},
+		{"docs", false},
+		{"x/docs/site", false},
+		{"[bad", false},
+	} {
+		if got := m.match(tc.rel); got != tc.want {
+			t.Errorf("match(%q) = %v; want %v", tc.rel, got, tc.want)
+		}
+	}
+}
@@ -23,6 +23,8 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"io/ioutil"
+	"log"
 	"os"
 	"path"
 	"path/filepath"
@@ -59,6 +61,11 @@ type fileInfo struct {
 	// "C" or anything from the standard library.
 	imports []string
 
+	// importComment is the import path declared in an import comment on the
+	// package clause of a .go file, as in package foo // import "x/foo".
+	// It is empty if there is no such comment.
+	importComment string
+
 	// isCgo is true for .go files that import "C".
 	isCgo bool
 
@@ -73,6 +80,13 @@ type fileInfo struct {
 	// copts and clinkopts contain flags that are part of CFLAGS, CPPFLAGS,
 	// CXXFLAGS, and LDFLAGS directives in cgo comments.
 	copts, clinkopts []taggedOpts
+
+	// callsTestingShort is true for test .go files that call testing.Short.
+	callsTestingShort bool
+
+	// generates contains the command lines of //go:generate comments in
+	// .go files, split into words.
+	generates [][]string
 }
 
 // taggedOpts a list of compile or link options which should only be applied
@@ -177,7 +191,16 @@ func fileNameInfo(dir, name string) fileInfo {
 func goFileInfo(c *config.Config, dir, name string) (fileInfo, error) {
 	info := fileNameInfo(dir, name)
 	fset := token.NewFileSet()
-	pf, err := parser.ParseFile(fset, info.path, nil, parser.ImportsOnly|parser.ParseComments)
+	mode := parser.ImportsOnly | parser.ParseComments
+	if info.isTest {
+		// Function bodies in tests are checked for calls to testing.Short.
+		mode = parser.ParseComments
+	}
+	src, err := ioutil.ReadFile(info.path)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	pf, err := parser.ParseFile(fset, info.path, src, mode)
 	if err != nil {
 		return fileInfo{}, err
 	}
@@ -188,6 +211,10 @@ func goFileInfo(c *config.Config, dir, name string) (fileInfo, error) {
 		info.packageName = info.packageName[:len(info.packageName)-len("_test")]
 	}
 
+	if !info.isTest {
+		info.importComment = findImportComment(fset, pf)
+	}
+
 	for _, decl := range pf.Decls {
 		d, ok := decl.(*ast.GenDecl)
 		if !ok {
@@ -230,9 +257,144 @@ func goFileInfo(c *config.Config, dir, name string) (fileInfo, error) {
 	}
 	info.tags = tags
 
+	if info.isTest {
+		info.callsTestingShort = callsTestingShort(pf)
+	}
+
+	info.generates, err = readGenerateDirectives(src, info.path, pf.Name.Name)
+	if err != nil {
+		return fileInfo{}, fmt.Errorf("%s: %v", info.path, err)
+	}
+
 	return info, nil
 }
 
+// findImportComment returns the import path in an import comment on the
+// same line as the package clause of "pf", or "" if there is no such
+// comment. pf must have been parsed with comments.
+func findImportComment(fset *token.FileSet, pf *ast.File) string {
+	line := fset.Position(pf.Name.End()).Line
+	for _, cg := range pf.Comments {
+		if cg.Pos() < pf.Name.End() {
+			continue
+		}
+		if fset.Position(cg.Pos()).Line != line {
+			break
+		}
+		text := cg.List[0].Text
+		if strings.HasPrefix(text, "//") {
+			text = text[len("//"):]
+		} else {
+			text = strings.TrimSuffix(text[len("/*"):], "*/")
+		}
+		text = strings.TrimSpace(text)
+		if !strings.HasPrefix(text, "import ") && !strings.HasPrefix(text, "import\t") {
+			return ""
+		}
+		importPath, err := strconv.Unquote(strings.TrimSpace(text[len("import"):]))
+		if err != nil {
+			return ""
+		}
+		return importPath
+	}
+	return ""
+}
+
+// ReadImportComment returns the import path in the import comment on the
+// package clause of the .go file at "path", or "" if there is none.
+func ReadImportComment(path string) (string, error) {
+	fset := token.NewFileSet()
+	pf, err := parser.ParseFile(fset, path, nil, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return "", err
+	}
+	return findImportComment(fset, pf), nil
+}
+
+// readGenerateDirectives extracts the command lines of //go:generate
+// comments in "src", the content of the file at "path" in the package
+// "pkg". As with "go generate", $GOFILE, $GOPACKAGE, and $DOLLAR are
+// expanded. Directives that use other variables, including $GOOS and
+// $GOARCH, are skipped, since their values would depend on the environment
+// Gazelle runs in rather than the platform being built.
+func readGenerateDirectives(src []byte, path, pkg string) ([][]string, error) {
+	const prefix = "//go:generate"
+	name := filepath.Base(path)
+	var generates [][]string
+	for _, line := range bytes.Split(src, []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte(prefix)) {
+			continue
+		}
+		rest := string(line[len(prefix):])
+		if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+			continue
+		}
+		var unknown []string
+		rest = os.Expand(strings.TrimSpace(rest), func(v string) string {
+			switch v {
+			case "GOFILE":
+				return name
+			case "GOPACKAGE":
+				return pkg
+			case "DOLLAR", "$":
+				return "$"
+			}
+			unknown = append(unknown, "$"+v)
+			return ""
+		})
+		if len(unknown) > 0 {
+			log.Printf("%s: skipping //go:generate directive that uses %s; only $GOFILE, $GOPACKAGE, and $DOLLAR are supported", path, strings.Join(unknown, ", "))
+			continue
+		}
+		args, err := splitQuoted(rest)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) > 0 {
+			generates = append(generates, args)
+		}
+	}
+	return generates, nil
+}
+
+// callsTestingShort returns whether the file "f" calls testing.Short. Tests
+// that do usually skip slow cases in short mode.
+func callsTestingShort(f *ast.File) bool {
+	testingName := ""
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err != nil || path != "testing" {
+			continue
+		}
+		testingName = "testing"
+		if spec.Name != nil {
+			testingName = spec.Name.Name
+		}
+	}
+	if testingName == "" || testingName == "_" {
+		return false
+	}
+
+	found := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		if found {
+			return false
+		}
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "Short" {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); ok && x.Name == testingName {
+			found = true
+		}
+		return true
+	})
+	return found
+}
+
 // saveCgo extracts CFLAGS, CPPFLAGS, CXXFLAGS, and LDFLAGS directives
 // from a comment above a "C" import. This is intended to match logic in
 // go/build.Context.saveCgo.
@@ -88,6 +88,36 @@ import (
 				imports:     []string{"github.com/foo/bar", "github.com/local/project/y"},
 			},
 		},
+		{
+			"import comment",
+			"foo.go",
+			`package foo // import "example.com/foo"
+
+// import "not/a/comment/on/the/package/clause"
+`,
+			fileInfo{
+				packageName:   "foo",
+				importComment: "example.com/foo",
+			},
+		},
+		{
+			"block import comment",
+			"foo.go",
+			"package foo /* import \"example.com/foo\" */\n",
+			fileInfo{
+				packageName:   "foo",
+				importComment: "example.com/foo",
+			},
+		},
+		{
+			"import comment in test ignored",
+			"foo_test.go",
+			"package foo // import \"example.com/foo\"\n",
+			fileInfo{
+				packageName: "foo",
+				isTest:      true,
+			},
+		},
 		{
 			"standard imports not included",
 			"foo.go",
@@ -141,6 +171,56 @@ package route
 				tags:        []string{"darwin dragonfly freebsd netbsd openbsd"},
 			},
 		},
+		{
+			"test calls testing.Short",
+			"foo_test.go",
+			`package foo
+
+import "testing"
+
+func TestFoo(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+}
+`,
+			fileInfo{
+				packageName:       "foo",
+				isTest:            true,
+				callsTestingShort: true,
+			},
+		},
+		{
+			"test calls renamed testing.Short",
+			"foo_test.go",
+			`package foo
+
+import tt "testing"
+
+var short = tt.Short()
+`,
+			fileInfo{
+				packageName:       "foo",
+				isTest:            true,
+				callsTestingShort: true,
+			},
+		},
+		{
+			"test calls other Short",
+			"foo_test.go",
+			`package foo
+
+import "testing"
+
+func TestFoo(t *testing.T) {
+	t.Short()
+}
+`,
+			fileInfo{
+				packageName: "foo",
+				isTest:      true,
+			},
+		},
 	} {
 		if err := ioutil.WriteFile(tc.name, []byte(tc.source), 0600); err != nil {
 			t.Fatal(err)
@@ -160,6 +240,9 @@ package route
 			imports:     got.imports,
 			isCgo:       got.isCgo,
 			tags:        got.tags,
+
+			importComment:     got.importComment,
+			callsTestingShort: got.callsTestingShort,
 		}
 
 		if !reflect.DeepEqual(got, tc.want) {
@@ -216,6 +299,32 @@ import "C"
 	}
 }
 
+func TestReadGenerateDirectives(t *testing.T) {
+	src := `package foo
+
+//go:generate stringer -type=Pill
+//go:generate mockgen -source=$GOFILE -package=$GOPACKAGE -destination="mock $GOFILE"
+//go:generate echo $HOME
+//go:generate echo ${DOLLAR}x
+//go:generate echo $GOOS
+//go:generates not a directive
+// go:generate not a directive
+//go:generate
+`
+	got, err := readGenerateDirectives([]byte(src), "foo.go", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"stringer", "-type=Pill"},
+		{"mockgen", "-source=foo.go", "-package=foo", "-destination=mock foo.go"},
+		{"echo", "$x"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
 func TestOtherFileInfo(t *testing.T) {
 	dir := "."
 	for _, tc := range []struct {
@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileKey uniquely identifies a file or directory by device and inode number.
+type fileKey struct {
+	dev, ino uint64
+}
+
+// getFileKey returns a fileKey for the file or directory at "p", following
+// symbolic links.
+func getFileKey(p string) (fileKey, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return fileKey{}, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, fmt.Errorf("%s: could not determine device and inode", p)
+	}
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, nil
+}
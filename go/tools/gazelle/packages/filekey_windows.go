@@ -0,0 +1,38 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// fileKey uniquely identifies a file or directory. Windows does not report
+// inode numbers through os.FileInfo, so the path with symbolic links
+// evaluated is used instead.
+type fileKey struct {
+	path string
+}
+
+// getFileKey returns a fileKey for the file or directory at "p", following
+// symbolic links.
+func getFileKey(p string) (fileKey, error) {
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return fileKey{}, err
+	}
+	return fileKey{path: strings.ToLower(target)}, nil
+}
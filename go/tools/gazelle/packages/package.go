@@ -17,6 +17,8 @@ package packages
 
 import (
 	"fmt"
+	"log"
+	"path"
 	"sort"
 	"strings"
 
@@ -39,17 +41,46 @@ type Package struct {
 	// Components in Rel are separated with slashes.
 	Rel string
 
+	// ImportComment is the import path declared by import comments in the
+	// package clauses of the package's .go files, as in
+	// package foo // import "example.com/foo". It is empty if there are no
+	// import comments. Import comments in tests are ignored.
+	ImportComment string
+
 	Library, CgoLibrary, Binary, Test, XTest Target
 
 	Protos      []string
 	HasPbGo     bool
 	HasTestdata bool
+
+	// Generates lists the //go:generate comments in .go files in the
+	// package, in the order they appear.
+	Generates []GenerateDirective
+}
+
+// GenerateDirective is a //go:generate comment in a .go file.
+type GenerateDirective struct {
+	// File is the name of the file containing the comment.
+	File string
+
+	// Args is the command line, split into words. $GOFILE, $GOPACKAGE, and
+	// $DOLLAR have been expanded. Directives that use other variables are
+	// not recorded.
+	Args []string
 }
 
 // Target contains metadata about a buildable Go target in a package.
 type Target struct {
 	Sources, Imports PlatformStrings
 	COpts, CLinkOpts PlatformStrings
+
+	// RequiresTestTags is true if the target has sources, and all of them
+	// are test sources that are only built when config.Config.TestTags are
+	// set.
+	RequiresTestTags bool
+
+	// CallsTestingShort is true if any test source calls testing.Short.
+	CallsTestingShort bool
 }
 
 // PlatformStrings contains a set of strings associated with a buildable
@@ -62,6 +93,52 @@ type PlatformStrings struct {
 	// Platform is a map of lists of platform-specific strings. The map is keyed
 	// by the name of the platform.
 	Platform map[string][]string
+
+	// Files maps each string to the name of the first source file it was
+	// found in. This is only recorded for imports, so that errors about
+	// dependencies can point to the file with the import.
+	Files map[string]string
+}
+
+// ImportPath returns the import path of the package. goPrefix is the import
+// path of the repository root. If the package has an import comment, the
+// path in the comment is returned instead, unless the package is vendored;
+// like the go command, Gazelle ignores import comments in vendored packages.
+func (p *Package) ImportPath(goPrefix string) string {
+	if p.ImportComment != "" && !IsVendored(p.Rel) {
+		return p.ImportComment
+	}
+	return ImportPath(goPrefix, p.Rel)
+}
+
+// ImportPath returns the import path of the package in the directory "rel",
+// a slash-separated path relative to the repository root. goPrefix is the
+// import path of the repository root. As with the go command, packages in a
+// vendor directory have import paths relative to the innermost vendor
+// directory that contains them.
+func ImportPath(goPrefix, rel string) string {
+	if i := vendorIndex(rel); i >= 0 {
+		return rel[i:]
+	}
+	return path.Join(goPrefix, rel)
+}
+
+// IsVendored returns whether the directory "rel" is inside a vendor
+// directory.
+func IsVendored(rel string) bool {
+	return vendorIndex(rel) >= 0
+}
+
+// vendorIndex returns the index in "rel" of the first character after the
+// innermost "vendor/" component, or -1 if there is no such component.
+func vendorIndex(rel string) int {
+	if i := strings.LastIndex(rel, "/vendor/"); i >= 0 {
+		return i + len("/vendor/")
+	}
+	if strings.HasPrefix(rel, "vendor/") {
+		return len("vendor/")
+	}
+	return -1
 }
 
 // IsCommand returns true if the package name is "main".
@@ -165,32 +242,101 @@ func (p *Package) addFile(c *config.Config, info fileInfo, cgo bool) error {
 		p.Protos = append(p.Protos, info.name)
 	}
 
+	if info.importComment != "" {
+		if p.ImportComment == "" {
+			p.ImportComment = info.importComment
+		} else if p.ImportComment != info.importComment {
+			log.Printf("%s: import comment %q disagrees with %q in other files in the package", info.path, info.importComment, p.ImportComment)
+		}
+	}
+
 	if strings.HasSuffix(info.name, ".pb.go") {
 		p.HasPbGo = true
 	}
 
+	for _, args := range info.generates {
+		p.Generates = append(p.Generates, GenerateDirective{File: info.name, Args: args})
+	}
+
 	return nil
 }
 
 func (t *Target) addFile(c *config.Config, info fileInfo) {
-	if !info.hasConstraints() || info.checkConstraints(c.GenericTags) {
+	// Test sources that aren't built on any platform are checked again with
+	// c.TestTags. The file is still included if it builds without them.
+	needsTestTags := info.isTest && len(c.TestTags) > 0 && !isBuilt(c, info)
+	tagsFor := func(tags config.BuildTags) config.BuildTags {
+		if needsTestTags {
+			return withTestTags(c, tags)
+		}
+		return tags
+	}
+
+	if !info.hasConstraints() || info.checkConstraints(tagsFor(c.GenericTags)) {
+		t.addTestInfo(info, needsTestTags)
 		t.Sources.addGenericStrings(info.name)
 		t.Imports.addGenericStrings(info.imports...)
+		t.Imports.addFile(info.name, info.imports...)
 		t.COpts.addGenericOpts(c.Platforms, info.copts)
 		t.CLinkOpts.addGenericOpts(c.Platforms, info.clinkopts)
 		return
 	}
 
 	for name, tags := range c.Platforms {
-		if info.checkConstraints(tags) {
+		if info.checkConstraints(tagsFor(tags)) {
+			t.addTestInfo(info, needsTestTags)
 			t.Sources.addPlatformStrings(name, info.name)
 			t.Imports.addPlatformStrings(name, info.imports...)
+			t.Imports.addFile(info.name, info.imports...)
 			t.COpts.addTaggedOpts(name, info.copts, tags)
 			t.CLinkOpts.addTaggedOpts(name, info.clinkopts, tags)
 		}
 	}
 }
 
+// isBuilt returns whether the file "info" is built with c.GenericTags or on
+// any platform in c.Platforms.
+func isBuilt(c *config.Config, info fileInfo) bool {
+	if !info.hasConstraints() || info.checkConstraints(c.GenericTags) {
+		return true
+	}
+	for _, tags := range c.Platforms {
+		if info.checkConstraints(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// withTestTags returns "tags" with c.TestTags added. "tags" is not
+// modified.
+func withTestTags(c *config.Config, tags config.BuildTags) config.BuildTags {
+	merged := make(config.BuildTags, len(tags)+len(c.TestTags))
+	for t := range tags {
+		merged[t] = true
+	}
+	for t := range c.TestTags {
+		merged[t] = true
+	}
+	return merged
+}
+
+// addTestInfo records information about the test file "info" that is
+// used to choose attributes for test rules. It must be called before the
+// file is added to t.Sources. "needsTestTags" is whether the file is only
+// built with c.TestTags.
+func (t *Target) addTestInfo(info fileInfo, needsTestTags bool) {
+	if !info.isTest {
+		return
+	}
+	t.CallsTestingShort = t.CallsTestingShort || info.callsTestingShort
+	if needsTestTags {
+		t.RequiresTestTags = !t.HasGo() || t.RequiresTestTags
+	} else {
+		t.RequiresTestTags = false
+	}
+}
+
 func (ps *PlatformStrings) addGenericStrings(ss ...string) {
 	ps.Generic = append(ps.Generic, ss...)
 }
@@ -220,6 +366,20 @@ func (ps *PlatformStrings) addPlatformStrings(name string, ss ...string) {
 	ps.Platform[name] = append(ps.Platform[name], ss...)
 }
 
+// addFile records that the strings "ss" were found in the file "name",
+// unless they were already found in another file.
+func (ps *PlatformStrings) addFile(name string, ss ...string) {
+	for _, s := range ss {
+		if _, ok := ps.Files[s]; ok {
+			continue
+		}
+		if ps.Files == nil {
+			ps.Files = make(map[string]string)
+		}
+		ps.Files[s] = name
+	}
+}
+
 func (ps *PlatformStrings) addTaggedOpts(name string, opts []taggedOpts, tags map[string]bool) {
 	for _, t := range opts {
 		if t.tags == "" || checkTags(t.tags, tags) {
@@ -97,3 +97,21 @@ func TestMapPlatformStrings(t *testing.T) {
 		t.Errorf("got errors %#v; want errors %#v", gotErrors, wantErrors)
 	}
 }
+
+func TestImportPath(t *testing.T) {
+	for _, tc := range []struct {
+		rel, want string
+	}{
+		{"", "example.com/repo"},
+		{"foo/bar", "example.com/repo/foo/bar"},
+		{"vendor", "example.com/repo/vendor"},
+		{"vendor/github.com/a/b", "github.com/a/b"},
+		{"cmd/vendor/github.com/a/b", "github.com/a/b"},
+		{"vendor/github.com/a/b/vendor/golang.org/x/c", "golang.org/x/c"},
+		{"vendorx/y", "example.com/repo/vendorx/y"},
+	} {
+		if got := ImportPath("example.com/repo", tc.rel); got != tc.want {
+			t.Errorf("ImportPath(%q) = %q; want %q", tc.rel, got, tc.want)
+		}
+	}
+}
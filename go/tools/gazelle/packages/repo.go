@@ -0,0 +1,233 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
+)
+
+const goModFileName = "go.mod"
+
+// ScanRepo searches the repository for nested repositories and for import
+// comments with a single walk through c.RepoRoot. The results are suitable
+// for c.NestedRepos and c.ImportComments; see FindImportComments.
+//
+// A directory is the root of a nested repository if it contains a WORKSPACE,
+// WORKSPACE.bazel, or go.mod file. Nested repositories inside other nested
+// repositories are not reported, and directories excluded by c.Excludes or
+// ignore files are not searched. No nested repositories are reported if
+// c.WalkNestedRepos is set.
+//
+// If c.NonRecursive is set, the repository is not walked. Only the
+// directories in c.Dirs and their parents are checked for nested
+// repositories, and no import comments are returned.
+//
+// The import path prefix of a nested repository is read from the module
+// declaration in go.mod if there is one. Otherwise, it is derived from
+// c.GoPrefix and the location of the repository.
+func ScanRepo(c *config.Config) (repos []config.NestedRepo, importComments map[string]string) {
+	if c.NonRecursive {
+		if c.WalkNestedRepos {
+			return nil, nil
+		}
+		return findNestedReposAbove(c), nil
+	}
+	return scanRepo(c)
+}
+
+func scanRepo(c *config.Config) ([]config.NestedRepo, map[string]string) {
+	var repos []config.NestedRepo
+	paths := make(map[string]string)
+	WalkDirs(c, c.RepoRoot, nil, func(d *Dir) bool {
+		for _, base := range d.NestedRepos {
+			repos = append(repos, nestedRepo(c, filepath.Join(d.Path, base), joinRel(d.Rel, base)))
+		}
+		if importCommentsApply(d.Rel) {
+			addImportComment(c, d, paths)
+		}
+		return false
+	})
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Rel < repos[j].Rel })
+	return repos, paths
+}
+
+// findNestedReposAbove returns the nested repositories that contain the
+// directories in c.Dirs, or that are rooted at them.
+func findNestedReposAbove(c *config.Config) []config.NestedRepo {
+	checked := make(map[string]bool) // rel -> whether it's a repository root
+	var repos []config.NestedRepo
+	for _, dir := range c.Dirs {
+		rel, err := relPath(c.RepoRoot, dir)
+		if err != nil || rel == "" {
+			continue
+		}
+		parts := strings.Split(rel, "/")
+		for i := range parts {
+			r := strings.Join(parts[:i+1], "/")
+			isRoot, ok := checked[r]
+			if !ok {
+				p := filepath.Join(c.RepoRoot, filepath.FromSlash(r))
+				isRoot = isRepoRootDir(p)
+				checked[r] = isRoot
+				if isRoot {
+					repos = append(repos, nestedRepo(c, p, r))
+				}
+			}
+			if isRoot {
+				break
+			}
+		}
+	}
+	return repos
+}
+
+// FindImportComments searches the repository for packages with import
+// comments that declare import paths different from the paths derived from
+// c.GoPrefix and their locations. It returns a map from declared import
+// paths to slash-separated directories relative to c.RepoRoot.
+//
+// Directories are visited as described in WalkDirs, so excluded
+// directories and nested repositories are skipped the same way. Vendored
+// packages and packages in testdata directories are ignored, as they are
+// by the go command. Use ScanRepo to find nested repositories in the same
+// walk.
+func FindImportComments(c *config.Config) map[string]string {
+	_, paths := scanRepo(c)
+	return paths
+}
+
+// addImportComment reads the import comment of the package in "d", if
+// there is one, and adds it to "paths" if it differs from the import path
+// derived from c.GoPrefix. Only the first file with a comment is read.
+func addImportComment(c *config.Config, d *Dir, paths map[string]string) {
+	for _, base := range d.Files {
+		if base == "" || base[0] == '.' || base[0] == '_' || !strings.HasSuffix(base, ".go") || strings.HasSuffix(base, "_test.go") {
+			continue
+		}
+		importPath, err := ReadImportComment(filepath.Join(d.Path, base))
+		if err != nil || importPath == "" {
+			continue
+		}
+		if importPath != ImportPath(c.GoPrefix, d.Rel) {
+			if _, ok := paths[importPath]; !ok {
+				paths[importPath] = d.Rel
+			}
+		}
+		return
+	}
+}
+
+// importCommentsApply returns whether import comments in the directory
+// "rel" are honored. They are ignored in vendor and testdata directories
+// and in directories whose names start with "_", and in their
+// subdirectories.
+func importCommentsApply(rel string) bool {
+	for _, part := range strings.Split(rel, "/") {
+		if part == "vendor" || part == "testdata" || strings.HasPrefix(part, "_") {
+			return false
+		}
+	}
+	return true
+}
+
+// isRepoRoot returns whether a directory with the given files is the root
+// of a repository.
+func isRepoRoot(files []os.FileInfo) bool {
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if base := f.Name(); wspace.IsFileName(base) || base == goModFileName {
+			return true
+		}
+	}
+	return false
+}
+
+// isRepoRootDir returns whether the directory "dir" is the root of a
+// repository. Errors reading the directory are ignored.
+func isRepoRootDir(dir string) bool {
+	files, err := ioutil.ReadDir(dir)
+	return err == nil && isRepoRoot(files)
+}
+
+// nestedRepoAbove returns the slash-separated path of the root of the
+// nested repository that contains the directory "rel", or "" if there is
+// none. rel itself is not checked.
+func nestedRepoAbove(c *config.Config, rel string) string {
+	if rel == "" {
+		return ""
+	}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		r := strings.Join(parts[:i], "/")
+		if isRepoRootDir(filepath.Join(c.RepoRoot, filepath.FromSlash(r))) {
+			return r
+		}
+	}
+	return ""
+}
+
+func nestedRepo(c *config.Config, dir, rel string) config.NestedRepo {
+	r := config.NestedRepo{Rel: rel}
+	if w, err := wspace.Read(dir); err == nil {
+		r.Name = w.Name
+	} else if !os.IsNotExist(err) {
+		log.Print(err)
+	}
+	if prefix, err := modulePath(filepath.Join(dir, goModFileName)); err == nil && prefix != "" {
+		r.GoPrefix = prefix
+	} else {
+		if err != nil && !os.IsNotExist(err) {
+			log.Print(err)
+		}
+		r.GoPrefix = path.Join(c.GoPrefix, rel)
+	}
+	return r
+}
+
+// modulePath returns the module path declared in the given go.mod file, or
+// "" if there is no module declaration.
+func modulePath(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "module" {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(fields[1]); err == nil {
+			return unquoted, nil
+		}
+		return fields[1], nil
+	}
+	return "", scanner.Err()
+}
@@ -0,0 +1,151 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+// symlinkFollower tracks the directories visited by Walk when symbolic
+// links are followed. Each directory is visited at most once, which prevents
+// infinite loops when links form cycles.
+type symlinkFollower struct {
+	// root is the repository root with symbolic links evaluated. Links to
+	// directories outside root are not followed.
+	root string
+
+	// walkRoot is the directory where the walk started with symbolic links
+	// evaluated. Links to directories the walk reaches through walkRoot are
+	// not followed, so those directories keep their real paths.
+	walkRoot string
+
+	// x matches excluded directories, which the walk does not reach.
+	x *excludeMatcher
+
+	// visited is the set of directories visited so far.
+	visited map[fileKey]bool
+}
+
+// newSymlinkFollower returns a symlinkFollower for a walk of "dir", or nil
+// if Walk should not follow symbolic links.
+func newSymlinkFollower(c *config.Config, dir string, x *excludeMatcher) *symlinkFollower {
+	if !c.FollowSymlinks {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(c.RepoRoot)
+	if err != nil {
+		log.Print(err)
+		root = c.RepoRoot
+	}
+	walkRoot, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		log.Print(err)
+		walkRoot = dir
+	}
+	return &symlinkFollower{
+		root:     root,
+		walkRoot: walkRoot,
+		x:        x,
+		visited:  make(map[fileKey]bool),
+	}
+}
+
+// enter records that the directory "dir" is being visited. It returns false
+// if the same directory was already visited, possibly through another path.
+func (s *symlinkFollower) enter(dir string) bool {
+	key, err := getFileKey(dir)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	if s.visited[key] {
+		log.Printf("%s: directory was already visited through another path; skipping", dir)
+		return false
+	}
+	s.visited[key] = true
+	return true
+}
+
+// isDirLink returns whether "p", a symbolic link, points to a directory inside
+// the repository root that should be followed. A diagnostic is logged for
+// links to directories outside the root. Links to directories the walk
+// visits anyway are not followed, so rules for those directories are
+// generated at their real paths, not at the paths of links that sort
+// before them. Links to the walk root or its parents and links to
+// directories already visited are not followed either, since the walk would
+// reach the same directories again.
+func (s *symlinkFollower) isDirLink(p string) bool {
+	fi, err := os.Stat(p)
+	if err != nil {
+		// Broken links are not an error; they are simply not followed.
+		return false
+	}
+	if !fi.IsDir() {
+		return false
+	}
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	if !isDescendant(target, s.root) {
+		log.Printf("%s: symbolic link points to %s, which is outside the repository root %s; skipping", p, target, s.root)
+		return false
+	}
+	if isDescendant(s.walkRoot, target) {
+		return false
+	}
+	if key, err := getFileKey(target); err != nil || s.visited[key] {
+		return false
+	}
+	return !s.isWalked(target)
+}
+
+// isWalked returns whether the walk reaches "dir", a directory inside the
+// repository root with symbolic links evaluated, without following links.
+// This is true if dir is inside the walk root, and neither dir nor any
+// directory between it and the walk root is hidden or excluded.
+func (s *symlinkFollower) isWalked(dir string) bool {
+	if !isDescendant(dir, s.walkRoot) {
+		return false
+	}
+	rel, err := relPath(s.root, dir)
+	if err != nil {
+		return false
+	}
+	walkRel, err := relPath(s.root, s.walkRoot)
+	if err != nil {
+		return false
+	}
+	for ; rel != walkRel && rel != "." && rel != ""; rel = path.Dir(rel) {
+		if strings.HasPrefix(path.Base(rel), ".") || s.x.match(rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDescendant returns whether "dir" is "root" or a subdirectory of "root".
+func isDescendant(dir, root string) bool {
+	return dir == root || strings.HasPrefix(dir, fmt.Sprintf("%s%c", root, filepath.Separator))
+}
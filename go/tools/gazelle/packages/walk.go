@@ -19,6 +19,7 @@ import (
 	"go/build"
 	"io/ioutil"
 	"log"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -30,6 +31,49 @@ import (
 // A WalkFunc is a callback called by Walk for each package.
 type WalkFunc func(pkg *Package, oldFile *bzl.File)
 
+// Dir describes a directory visited by WalkDirs.
+type Dir struct {
+	// Path is the absolute path to the directory.
+	Path string
+
+	// Rel is the slash-separated path to the directory from the repository
+	// root. If the directory is the repository root itself, Rel is empty.
+	Rel string
+
+	// Config is the configuration for this directory. If WalkDirs was called
+	// with a ConfigureFunc, this is a copy of the configuration for the parent
+	// directory, modified by that function.
+	Config *config.Config
+
+	// Files is a sorted list of the names of files in the directory, not
+	// including subdirectories and excluded files.
+	Files []string
+
+	// HasTestdata is true if the directory has a "testdata" subdirectory
+	// which does not contain a Bazel package.
+	HasTestdata bool
+
+	// NestedRepos is a sorted list of the names of subdirectories that are
+	// roots of nested repositories. WalkDirs does not visit them. It is
+	// empty if c.WalkNestedRepos is set.
+	NestedRepos []string
+
+	// OldFile is the existing build file in the directory, or nil if there is
+	// no build file.
+	OldFile *bzl.File
+}
+
+// A ConfigureFunc is called by WalkDirs for each directory before any of
+// its subdirectories are visited. It may modify "c", which is a copy of the
+// configuration of the parent directory. "f" is the existing build file in
+// the directory, or nil if there is none.
+type ConfigureFunc func(c *config.Config, rel string, f *bzl.File)
+
+// A DirFunc is a callback called by WalkDirs for each directory. It returns
+// whether rules were generated for the directory, i.e., whether the directory
+// is a Bazel package.
+type DirFunc func(d *Dir) bool
+
 // Walk walks through directories under "root".
 // It calls back "f" for each package. If an existing BUILD file is present
 // in the directory, it will be parsed and passed to "f" as well.
@@ -45,31 +89,95 @@ type WalkFunc func(pkg *Package, oldFile *bzl.File)
 // other packages will be silently ignored. If none of the package names match
 // the directory name, or if some other error occurs, an error will be logged,
 // and "f" will not be called.
+//
+// Directories are visited as described in WalkDirs.
 func Walk(c *config.Config, dir string, f WalkFunc) {
+	WalkDirs(c, dir, nil, func(d *Dir) bool {
+		pkg := FindPackage(d)
+		if pkg == nil {
+			return false
+		}
+		f(pkg, d.OldFile)
+		return true
+	})
+}
+
+// WalkDirs walks through directories under "dir" in post-order. It calls
+// "f" for each directory. If an existing BUILD file is present in the
+// directory, it will be parsed and passed to "f" as part of the Dir. If
+// a directory contains multiple build files, or if its build file can't be
+// parsed, an error is logged, and "f" is not called for that directory.
+//
+// If "configure" is not nil, it is called for each directory before its
+// subdirectories are visited. The configuration it produces is inherited by
+// subdirectories.
+//
+// Unless c.WalkNestedRepos is set, WalkDirs does not descend into
+// subdirectories that contain a WORKSPACE or go.mod file. These are the roots
+// of separate repositories; they are listed in Dir.NestedRepos instead. If
+// "dir" is inside one of them, nothing is visited.
+//
+// If c.FollowSymlinks is set, WalkDirs follows symbolic links to directories
+// inside the repository root. Each directory is visited at most once, so
+// cycles of links are not a problem. Links to directories outside the
+// repository root are reported and skipped.
+func WalkDirs(c *config.Config, dir string, configure ConfigureFunc, f DirFunc) {
+	walkDirs(c, dir, configure, f, true)
+}
+
+// VisitDir is like WalkDirs, but it only visits "dir", not its
+// subdirectories. Build files in parent directories are still read to
+// configure "dir". A "testdata" subdirectory is considered a data
+// dependency if it doesn't have a build file.
+func VisitDir(c *config.Config, dir string, configure ConfigureFunc, f DirFunc) {
+	walkDirs(c, dir, configure, f, false)
+}
+
+func walkDirs(c *config.Config, dir string, configure ConfigureFunc, f DirFunc, recursive bool) {
+	x := newExcludeMatcher(c)
+	follow := newSymlinkFollower(c, dir, x)
+
 	// visit walks the directory tree in post-order. It returns whether the
 	// the directory it was called on or any subdirectory contains a Bazel
 	// package. This affects whether "testdata" directories are considered
-	// data dependencies.
-	var visit func(string) bool
-	visit = func(path string) bool {
+	// data dependencies. It also returns whether the directory is the root
+	// of a nested repository, in which case it is not visited.
+	var visit func(*config.Config, string, string) (hasPackage, isRepo bool)
+	visit = func(c *config.Config, path, rel string) (bool, bool) {
 		files, err := ioutil.ReadDir(path)
 		if err != nil {
 			log.Print(err)
-			return false
+			return false, false
 		}
-		subdirHasPackage := false
+		if rel != "" && !c.WalkNestedRepos && isRepoRoot(files) {
+			return false, true
+		}
+		if follow != nil && !follow.enter(path) {
+			return false, false
+		}
+
+		// Find the build file and the subdirectories first. The build file
+		// may change the configuration for subdirectories.
 		var oldFile *bzl.File
-		hasTestdata := false
+		var regularFiles, subdirs []string
 		skip := false
 		for _, f := range files {
 			base := f.Name()
-			if f.IsDir() && base != "" && base[0] != '.' {
-				hasPackage := visit(filepath.Join(path, base))
-				if base == "testdata" {
-					hasTestdata = !hasPackage
+			if x.match(joinRel(rel, base)) {
+				continue
+			}
+			isDir := f.IsDir()
+			if !isDir && follow != nil && f.Mode()&os.ModeSymlink != 0 {
+				isDir = follow.isDirLink(filepath.Join(path, base))
+			}
+			if isDir {
+				if base != "" && base[0] != '.' {
+					subdirs = append(subdirs, base)
 				}
-				subdirHasPackage = subdirHasPackage || hasPackage
-			} else if !f.IsDir() && c.IsValidBuildFileName(base) {
+				continue
+			}
+			regularFiles = append(regularFiles, base)
+			if c.IsValidBuildFileName(base) {
 				if oldFile != nil {
 					log.Printf("in directory %s, multiple Bazel files are present: %s, %s",
 						path, filepath.Base(oldFile.Path), base)
@@ -92,52 +200,149 @@ func Walk(c *config.Config, dir string, f WalkFunc) {
 			}
 		}
 
+		if configure != nil {
+			c = c.Clone()
+			configure(c, rel, oldFile)
+		}
+
+		subdirHasPackage := false
+		hasTestdata := false
+		var nestedRepos []string
+		for _, base := range subdirs {
+			if !recursive {
+				if base == "testdata" {
+					hasTestdata = ReadBuildFile(c, filepath.Join(path, base)) == nil
+				}
+				continue
+			}
+			hasPackage, isRepo := visit(c, filepath.Join(path, base), joinRel(rel, base))
+			if isRepo {
+				nestedRepos = append(nestedRepos, base)
+				continue
+			}
+			if base == "testdata" {
+				hasTestdata = !hasPackage
+			}
+			subdirHasPackage = subdirHasPackage || hasPackage
+		}
+
 		hasPackage := subdirHasPackage || oldFile != nil
 		if skip {
-			return hasPackage
+			return hasPackage, false
+		}
+
+		d := &Dir{
+			Path:        path,
+			Rel:         rel,
+			Config:      c,
+			Files:       regularFiles,
+			HasTestdata: hasTestdata,
+			NestedRepos: nestedRepos,
+			OldFile:     oldFile,
+		}
+		if f(d) {
+			return true, false
 		}
+		return hasPackage, false
+	}
 
-		if pkg := findPackage(c, path, oldFile, hasTestdata); pkg != nil {
-			f(pkg, oldFile)
-			return true
+	rel, err := relPath(c.RepoRoot, dir)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if x.match(rel) {
+		return
+	}
+	if !c.WalkNestedRepos {
+		if repoRel := nestedRepoAbove(c, rel); repoRel != "" {
+			log.Printf("%s: directory is inside the nested repository %s; skipping", dir, repoRel)
+			return
 		}
-		return hasPackage
 	}
+	if configure != nil && rel != "" {
+		// Directives in parent directories apply to dir, too.
+		c = configureParents(c, rel, configure)
+	}
+	if _, isRepo := visit(c, dir, rel); isRepo {
+		log.Printf("%s: directory is the root of a nested repository; skipping", dir)
+	}
+}
 
-	visit(dir)
+// configureParents applies "configure" to the configuration for each
+// directory above "rel", starting at the repository root, and returns the
+// configuration for the parent of rel.
+func configureParents(c *config.Config, rel string, configure ConfigureFunc) *config.Config {
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		parent := strings.Join(parts[:i], "/")
+		c = c.Clone()
+		configure(c, parent, ReadBuildFile(c, filepath.Join(c.RepoRoot, filepath.FromSlash(parent))))
+	}
+	return c
 }
 
-// findPackage reads source files in a given directory and returns a Package
-// containing information about those files and how to build them.
-//
-// If no buildable .go files are found in the directory, nil will be returned.
-// If the directory contains multiple buildable packages, the package whose
-// name matches the directory base name will be returned. If there is no such
-// package or if an error occurs, an error will be logged, and nil will be
-// returned.
-func findPackage(c *config.Config, dir string, oldFile *bzl.File, hasTestdata bool) *Package {
-	rel, err := filepath.Rel(c.RepoRoot, dir)
+// ReadBuildFile reads and parses the build file in "dir". The names in
+// c.ValidBuildFileNames are checked in order. nil is returned if there is
+// no build file or if it can't be read; errors are logged.
+func ReadBuildFile(c *config.Config, dir string) *bzl.File {
+	for _, base := range c.ValidBuildFileNames {
+		p := filepath.Join(dir, base)
+		data, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
+		f, err := bzl.Parse(p, data)
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
+		return f
+	}
+	return nil
+}
+
+// relPath returns the slash-separated path from the repository root to dir.
+// If dir is the repository root, "" is returned.
+func relPath(root, dir string) (string, error) {
+	rel, err := filepath.Rel(root, dir)
 	if err != nil {
-		log.Print(err)
-		return nil
+		return "", err
 	}
 	rel = filepath.ToSlash(rel)
 	if rel == "." {
 		rel = ""
 	}
+	return rel, nil
+}
+
+// joinRel returns the slash-separated path of a file named "base" in the
+// directory "rel".
+func joinRel(rel, base string) string {
+	return path.Join(rel, base)
+}
 
+// FindPackage reads source files in the directory "d" and returns a Package
+// containing information about those files and how to build them.
+//
+// If no buildable .go files are found in the directory, nil will be returned.
+// If the directory contains multiple buildable packages, the package whose
+// name matches the directory base name will be returned. If there is no such
+// package or if an error occurs, an error will be logged, and nil will be
+// returned.
+func FindPackage(d *Dir) *Package {
+	c := d.Config
+	dir, rel := d.Path, d.Rel
 	var goFiles, otherFiles []string
 
-	// List the files in the directory and split into .go files and other files.
-	// We need to process the Go files first to determine which package we'll
-	// generate rules for if there are multiple packages.
-	files, err := ioutil.ReadDir(dir)
-	if err != nil {
-		log.Print(err)
-		return nil
-	}
-	for _, file := range files {
-		name := file.Name()
+	// Split the files into .go files and other files. We need to process the
+	// Go files first to determine which package we'll generate rules for if
+	// there are multiple packages.
+	for _, name := range d.Files {
 		if name == "" || name[0] == '.' || name[0] == '_' {
 			continue
 		}
@@ -170,7 +375,7 @@ func findPackage(c *config.Config, dir string, oldFile *bzl.File, hasTestdata bo
 				Name:        info.packageName,
 				Dir:         dir,
 				Rel:         rel,
-				HasTestdata: hasTestdata,
+				HasTestdata: d.HasTestdata,
 			}
 		}
 		err = packageMap[info.packageName].addFile(c, info, false)
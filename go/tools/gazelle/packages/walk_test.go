@@ -20,6 +20,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -350,3 +351,318 @@ func TestMalformedGoFile(t *testing.T) {
 	}
 	checkFiles(t, files, "", want)
 }
+
+func TestNestedRepos(t *testing.T) {
+	files := []fileSpec{
+		{path: "a/a.go", content: "package a"},
+		{path: "ws/WORKSPACE"},
+		{path: "ws/b.go", content: "package ws"},
+		{path: "mod/go.mod", content: "module example.com/mod"},
+		{path: "mod/c.go", content: "package mod"},
+	}
+	want := []*packages.Package{
+		{
+			Name: "a",
+			Rel:  "a",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"a.go"},
+				},
+			},
+		},
+	}
+	checkFiles(t, files, "", want)
+}
+
+func TestWalkNestedRepos(t *testing.T) {
+	files := []fileSpec{
+		{path: "ws/WORKSPACE"},
+		{path: "ws/b.go", content: "package ws"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config.Config{
+		RepoRoot:            dir,
+		ValidBuildFileNames: config.DefaultValidBuildFileNames,
+		WalkNestedRepos:     true,
+	}
+	var rels []string
+	packages.Walk(c, dir, func(pkg *packages.Package, _ *bzl.File) {
+		rels = append(rels, pkg.Rel)
+	})
+	if want := []string{"ws"}; !reflect.DeepEqual(rels, want) {
+		t.Errorf("got packages %q; want %q", rels, want)
+	}
+}
+
+func TestWalkInsideNestedRepo(t *testing.T) {
+	files := []fileSpec{
+		{path: "ws/WORKSPACE"},
+		{path: "ws/b.go", content: "package ws"},
+		{path: "ws/sub/c.go", content: "package sub"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config.Config{
+		RepoRoot:            dir,
+		ValidBuildFileNames: config.DefaultValidBuildFileNames,
+	}
+	for _, start := range []string{"ws", "ws/sub"} {
+		p := filepath.Join(dir, filepath.FromSlash(start))
+		var rels []string
+		f := func(d *packages.Dir) bool {
+			rels = append(rels, d.Rel)
+			return false
+		}
+		packages.WalkDirs(c, p, nil, f)
+		packages.VisitDir(c, p, nil, f)
+		if len(rels) > 0 {
+			t.Errorf("starting in %s: visited %q; want nothing", start, rels)
+		}
+	}
+}
+
+func TestScanRepo(t *testing.T) {
+	files := []fileSpec{
+		{path: "a/a.go", content: `package a // import "example.com/other/a"`},
+		{path: "named/WORKSPACE", content: `workspace(name = "com_example_named")`},
+		{path: "named/inner/WORKSPACE"},
+		{path: "bazel/WORKSPACE.bazel", content: `workspace(name = "com_example_bazel")`},
+		{path: "mod/go.mod", content: "module \"example.com/mod\"\n"},
+		{path: "x/y/WORKSPACE"},
+		{path: ".hidden/WORKSPACE"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config.Config{
+		RepoRoot: dir,
+		GoPrefix: "example.com/repo",
+	}
+	got, comments := packages.ScanRepo(c)
+	want := []config.NestedRepo{
+		{Rel: "bazel", Name: "com_example_bazel", GoPrefix: "example.com/repo/bazel"},
+		{Rel: "mod", GoPrefix: "example.com/mod"},
+		{Rel: "named", Name: "com_example_named", GoPrefix: "example.com/repo/named"},
+		{Rel: "x/y", GoPrefix: "example.com/repo/x/y"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+	if wantComments := map[string]string{"example.com/other/a": "a"}; !reflect.DeepEqual(comments, wantComments) {
+		t.Errorf("got import comments %v; want %v", comments, wantComments)
+	}
+
+	// In non-recursive mode, only the listed directories and their parents
+	// are checked.
+	c.NonRecursive = true
+	c.Dirs = []string{
+		filepath.Join(dir, "a"),
+		filepath.Join(dir, "named", "inner"),
+		filepath.Join(dir, "mod"),
+	}
+	got, comments = packages.ScanRepo(c)
+	if comments != nil {
+		t.Errorf("non-recursive: got import comments %v; want nil", comments)
+	}
+	want = []config.NestedRepo{
+		{Rel: "named", Name: "com_example_named", GoPrefix: "example.com/repo/named"},
+		{Rel: "mod", GoPrefix: "example.com/mod"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("non-recursive: got %#v; want %#v", got, want)
+	}
+}
+
+func TestFindImportComments(t *testing.T) {
+	files := []fileSpec{
+		{path: "a/a.go", content: `package a // import "example.com/other/a"`},
+		{path: "a/b.go", content: `package a // import "example.com/wrong"`},
+		{path: "same/same.go", content: `package same // import "example.com/repo/same"`},
+		{path: "test/x_test.go", content: `package test // import "example.com/test"`},
+		{path: "vendor/v/v.go", content: `package v // import "example.com/v"`},
+		{path: "nested/WORKSPACE"},
+		{path: "nested/n.go", content: `package n // import "example.com/n"`},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config.Config{
+		RepoRoot: dir,
+		GoPrefix: "example.com/repo",
+	}
+	got := packages.FindImportComments(c)
+	want := map[string]string{"example.com/other/a": "a"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestImportComment(t *testing.T) {
+	files := []fileSpec{
+		{path: "lib/a.go", content: `package lib // import "example.com/canonical"`},
+		{path: "lib/b.go", content: `package lib /* import "example.com/other" */`},
+		{path: "lib/b_test.go", content: `package lib // import "example.com/test"`},
+		{path: "vendor/v/v.go", content: `package v // import "example.com/v"`},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	got := make(map[string]string)
+	for _, pkg := range walkPackages(dir, "example.com/repo", dir) {
+		got[pkg.Rel] = pkg.ImportPath("example.com/repo")
+	}
+	want := map[string]string{
+		"lib":      "example.com/canonical",
+		"vendor/v": "v",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got import paths %v; want %v", got, want)
+	}
+}
+
+func TestExcludes(t *testing.T) {
+	files := []fileSpec{
+		{path: ".bazelignore", content: "# comment\nbazel_out\n"},
+		{path: ".gazelleignore", content: "docs\n"},
+		{path: "a/a.go", content: "package a"},
+		{path: "a/gen.go", content: "package a"},
+		{path: "a/node_modules/b/b.go", content: "package b"},
+		{path: "bazel_out/c.go", content: "package bazel_out"},
+		{path: "docs/d.go", content: "package docs"},
+		{path: "gen/e.go", content: "package gen"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config.Config{
+		RepoRoot:            dir,
+		ValidBuildFileNames: config.DefaultValidBuildFileNames,
+		Excludes:            []string{"node_modules", "a/gen.go", "gen"},
+	}
+	var got []*packages.Package
+	packages.Walk(c, dir, func(pkg *packages.Package, _ *bzl.File) {
+		got = append(got, pkg)
+	})
+	want := []*packages.Package{
+		{
+			Name: "a",
+			Dir:  filepath.Join(dir, "a"),
+			Rel:  "a",
+			Library: packages.Target{
+				Sources: packages.PlatformStrings{
+					Generic: []string{"a.go"},
+				},
+			},
+		},
+	}
+	checkPackages(t, got, want)
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links are not reliably supported on Windows")
+	}
+	files := []fileSpec{
+		{path: "repo/real/a.go", content: "package real"},
+		{path: "repo/.src/hidden/h.go", content: "package hidden"},
+		{path: "repo/sub/"},
+		{path: "outside/b.go", content: "package outside"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repoRoot := filepath.Join(dir, "repo")
+	for _, link := range []struct{ old, new string }{
+		// "linked" sorts before its target, which must still be visited at
+		// its real path.
+		{"real", "repo/linked"},
+		{"..", "repo/real/cycle"},
+		{"../outside", "repo/out"},
+		{".src/hidden", "repo/shown"},
+		{"../real", "repo/sub/lib"},
+	} {
+		if err := os.Symlink(link.old, filepath.Join(dir, link.new)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		follow bool
+		start  string
+		want   []string
+	}{
+		{follow: false, want: []string{"real"}},
+		{follow: true, want: []string{"real", "shown"}},
+		// Links to directories outside the walk are followed.
+		{follow: true, start: "sub", want: []string{"sub/lib"}},
+	} {
+		c := &config.Config{
+			RepoRoot:            repoRoot,
+			ValidBuildFileNames: config.DefaultValidBuildFileNames,
+			FollowSymlinks:      tc.follow,
+		}
+		var rels []string
+		packages.Walk(c, filepath.Join(repoRoot, tc.start), func(pkg *packages.Package, _ *bzl.File) {
+			rels = append(rels, pkg.Rel)
+		})
+		if !reflect.DeepEqual(rels, tc.want) {
+			t.Errorf("with FollowSymlinks = %v, starting in %q, got packages %q; want %q", tc.follow, tc.start, rels, tc.want)
+		}
+	}
+}
+
+func TestVisitDir(t *testing.T) {
+	files := []fileSpec{
+		{path: "a/a.go", content: "package a"},
+		{path: "a/b/b.go", content: "package b"},
+		{path: "a/testdata/data.txt"},
+		{path: "c/c.go", content: "package c"},
+	}
+	dir, err := createFiles(files)
+	if err != nil {
+		t.Fatalf("createFiles() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &config.Config{
+		RepoRoot:            dir,
+		ValidBuildFileNames: config.DefaultValidBuildFileNames,
+	}
+	var rels []string
+	var hasTestdata bool
+	packages.VisitDir(c, filepath.Join(dir, "a"), nil, func(d *packages.Dir) bool {
+		rels = append(rels, d.Rel)
+		hasTestdata = d.HasTestdata
+		return true
+	})
+	if want := []string{"a"}; !reflect.DeepEqual(rels, want) {
+		t.Errorf("got directories %q; want %q", rels, want)
+	}
+	if !hasTestdata {
+		t.Errorf("got HasTestdata false; want true")
+	}
+}
@@ -0,0 +1,190 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+const (
+	// goName is the name of the Go language and the key for goConfig in
+	// config.Config.Exts.
+	goName = "go"
+
+	publicVisibility  = "//visibility:public"
+	privateVisibility = "//visibility:private"
+)
+
+// goConfig contains Go-specific configuration for a directory. It is set
+// by directives in build files and inherited by subdirectories.
+type goConfig struct {
+	// defaultVisibility is the visibility of libraries and binaries that
+	// are not otherwise restricted. If empty, they are public.
+	defaultVisibility []string
+
+	// naming contains templates for the names of generated targets.
+	naming naming
+
+	// testSize, testTimeout, and testShardCount are set on generated tests
+	// unless they are empty or zero.
+	testSize, testTimeout string
+	testShardCount        int
+
+	// testTags are added to the tags of generated tests.
+	testTags []string
+
+	// manualBuildTags are build tags which mark tests that should not be run
+	// by default. Test sources that only build with one of these tags are
+	// included in tests, and tests with no other sources are tagged "manual".
+	manualBuildTags []string
+
+	// combineTests is true if internal and external tests should be
+	// built by a single go_test rule, as "go test" builds them.
+	combineTests bool
+
+	// mocks are mock packages declared in this directory. Unlike other
+	// settings, they are not inherited by subdirectories.
+	mocks []mockPackage
+
+	// mockIndex finds mock packages declared in other directories. It is
+	// shared by all directories.
+	mockIndex *mockIndex
+
+	// names finds naming templates for other directories. It is shared by
+	// all directories.
+	names *namingIndex
+
+	// vendored finds packages in vendor directories. It is shared by all
+	// directories, so it only checks whether each directory exists once.
+	vendored *vendoredResolver
+
+	// gen is the generator for this directory. It is created by
+	// generatorFor when rules are first generated or resolved, and it is
+	// not inherited by subdirectories.
+	gen *generator
+}
+
+// getGoConfig returns the Go configuration for the directory configured by
+// "c". A default configuration is returned if none has been set.
+func getGoConfig(c *config.Config) *goConfig {
+	if gc, ok := c.Exts[goName].(*goConfig); ok {
+		return gc
+	}
+	return &goConfig{
+		naming:          defaultNaming,
+		manualBuildTags: []string{"integration"},
+	}
+}
+
+var (
+	testSizes    = map[string]bool{"small": true, "medium": true, "large": true, "enormous": true}
+	testTimeouts = map[string]bool{"short": true, "moderate": true, "long": true, "eternal": true}
+)
+
+// configure applies directives in "f" (the build file in the directory
+// "rel") to the configuration inherited from the parent directory, and
+// stores the result in c.Exts.
+func configure(c *config.Config, rel string, f *bzl.File) {
+	gc := *getGoConfig(c)
+	if gc.names == nil {
+		gc.names = newNamingIndex(c)
+	}
+	if gc.mockIndex == nil {
+		gc.mockIndex = newMockIndex(c)
+	}
+	if gc.vendored == nil {
+		gc.vendored = newVendoredResolver(c.RepoRoot, externalResolver{}, gc.names.libraryName)
+	}
+	gc.mocks = nil
+	gc.gen = nil
+	for _, d := range config.ParseDirectives(f) {
+		if gc.naming.applyDirective(rel, d) {
+			continue
+		}
+		switch d.Key {
+		case "default_visibility":
+			gc.defaultVisibility = splitList(d.Value)
+			if len(gc.defaultVisibility) == 0 {
+				log.Printf("%s: gazelle:default_visibility requires at least one label", rel)
+			}
+		case "combine_tests":
+			combine, err := strconv.ParseBool(d.Value)
+			if err != nil {
+				log.Printf("%s: gazelle:combine_tests: %v", rel, err)
+				continue
+			}
+			gc.combineTests = combine
+		case "mock":
+			m, err := parseMockDirective(d.Value)
+			if err != nil {
+				log.Printf("%s: gazelle:mock: %v", rel, err)
+				continue
+			}
+			gc.mocks = append(gc.mocks, m)
+		case "test_size":
+			if !testSizes[d.Value] {
+				log.Printf("%s: gazelle:test_size: unknown size %q", rel, d.Value)
+				continue
+			}
+			gc.testSize = d.Value
+		case "test_timeout":
+			if !testTimeouts[d.Value] {
+				log.Printf("%s: gazelle:test_timeout: unknown timeout %q", rel, d.Value)
+				continue
+			}
+			gc.testTimeout = d.Value
+		case "test_shard_count":
+			n, err := strconv.Atoi(d.Value)
+			if err != nil || n < 0 {
+				log.Printf("%s: gazelle:test_shard_count: %q is not a non-negative integer", rel, d.Value)
+				continue
+			}
+			gc.testShardCount = n
+		case "test_tags":
+			gc.testTags = splitList(d.Value)
+		case "manual_build_tags":
+			gc.manualBuildTags = splitList(d.Value)
+		}
+	}
+	if c.Exts == nil {
+		c.Exts = make(map[string]interface{})
+	}
+	c.Exts[goName] = &gc
+
+	// Test sources that only build with manual build tags are included in
+	// tests, which setTestAttrs tags "manual" if they have no other sources.
+	c.TestTags = make(config.BuildTags)
+	for _, t := range gc.manualBuildTags {
+		c.TestTags[t] = true
+	}
+}
+
+// splitList splits a comma-separated directive value. Spaces around
+// elements and empty elements are removed.
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,100 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"reflect"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+func TestRestrictedVisibility(t *testing.T) {
+	for _, tc := range []struct {
+		rel, want string
+	}{
+		{"", ""},
+		{"lib", ""},
+		{"internal", "//:__subpackages__"},
+		{"internal/lib", "//:__subpackages__"},
+		{"a/internal", "//a:__subpackages__"},
+		{"a/internal/b/internal/c", "//a/internal/b:__subpackages__"},
+		{"vendor/example.com/x", "//:__subpackages__"},
+		{"cmd/vendor/example.com/x", "//cmd:__subpackages__"},
+		{"vendor/example.com/x/internal/y", "//vendor/example.com/x:__subpackages__"},
+		{"internalize/lib", ""},
+	} {
+		if got := restrictedVisibility(tc.rel); got != tc.want {
+			t.Errorf("restrictedVisibility(%q) = %q; want %q", tc.rel, got, tc.want)
+		}
+	}
+}
+
+func TestDefaultVisibilityDirective(t *testing.T) {
+	parse := func(content string) *bzl.File {
+		f, err := bzl.Parse("BUILD", []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	root := &config.Config{DepMode: config.ExternalMode}
+	configure(root, "", nil)
+	restricted := root.Clone()
+	configure(restricted, "restricted", parse("# gazelle:default_visibility //restricted:__subpackages__, //tools:__pkg__\n"))
+	reset := restricted.Clone()
+	configure(reset, "restricted/public", parse("# gazelle:default_visibility //visibility:public\n"))
+
+	for _, tc := range []struct {
+		c    *config.Config
+		rel  string
+		want []string
+	}{
+		{root, "lib", []string{publicVisibility}},
+		{root, "lib/internal/x", []string{"//lib:__subpackages__"}},
+		{restricted, "restricted/lib", []string{"//restricted:__subpackages__", "//tools:__pkg__"}},
+		{restricted, "restricted/internal/x", []string{"//restricted:__subpackages__", "//tools:__pkg__"}},
+		{reset, "restricted/public/lib", []string{publicVisibility}},
+		{reset, "restricted/public/internal", []string{"//restricted/public:__subpackages__"}},
+	} {
+		g := newGenerator(tc.c)
+		if got := g.visibility(tc.rel); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("visibility(%q) = %q; want %q", tc.rel, got, tc.want)
+		}
+	}
+	if got := getGoConfig(root).defaultVisibility; got != nil {
+		t.Errorf("directive in subdirectory changed root configuration: %q", got)
+	}
+}
+
+func TestSharedIndexes(t *testing.T) {
+	root := &config.Config{DepMode: config.ExternalMode}
+	configure(root, "", nil)
+	sub := root.Clone()
+	configure(sub, "sub", nil)
+
+	rootConfig, subConfig := getGoConfig(root), getGoConfig(sub)
+	if rootConfig.vendored == nil || rootConfig.vendored != subConfig.vendored {
+		t.Errorf("vendored resolver is not shared by subdirectories")
+	}
+	if g := generatorFor(sub); g == nil || g != generatorFor(sub) {
+		t.Errorf("generatorFor returned a different generator for the same directory")
+	}
+	if generatorFor(root) == generatorFor(sub) {
+		t.Errorf("generatorFor returned the same generator for different directories")
+	}
+}
@@ -18,19 +18,24 @@ package rules
 import (
 	"fmt"
 	"log"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	bzl "github.com/bazelbuild/buildtools/build"
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/graph"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/lang"
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
 )
 
 const (
-	// goRulesBzl is the label of the Skylark file which provides Go rules
-	goRulesBzl = "@io_bazel_rules_go//go:def.bzl"
+	// GoRulesBzl is the label of the Skylark file which provides Go rules.
+	GoRulesBzl = "@io_bazel_rules_go//go:def.bzl"
 	// defaultLibName is the name of the default go_library rule in a Go
 	// package directory. It must be consistent to DEFAULT_LIB in go/private/common.bzl.
+	// Libraries may be named differently with naming directives.
 	defaultLibName = "go_default_library"
 	// defaultTestName is a name of an internal test corresponding to
 	// defaultLibName. It does not need to be consistent to something but it
@@ -39,9 +44,9 @@ const (
 	// defaultXTestName is a name of an external test corresponding to
 	// defaultLibName.
 	defaultXTestName = "go_default_xtest"
-	// defaultProtosName is the name of a filegroup created
-	// whenever the library contains .pb.go files
-	defaultProtosName = "go_default_library_protos"
+	// protosSuffix is appended to the library name to form the name of a
+	// filegroup created whenever the library contains .pb.go files
+	protosSuffix = "_protos"
 	// defaultCgoLibName is the name of the default cgo_library rule in a Go package directory.
 	defaultCgoLibName = "cgo_default_library"
 )
@@ -57,181 +62,460 @@ type Generator interface {
 }
 
 func NewGenerator(c *config.Config) Generator {
-	var (
-		// TODO(yugui) Support another resolver to cover the pattern 2 in
-		// https://github.com/bazelbuild/rules_go/issues/16#issuecomment-216010843
-		r = structuredResolver{goPrefix: c.GoPrefix}
-	)
+	g := newGenerator(c)
+	if g == nil {
+		return nil
+	}
+	return g
+}
 
-	var e labelResolver
+// newGenerator returns a generator for c, or nil if c.DepMode is invalid.
+func newGenerator(c *config.Config) *generator {
 	switch c.DepMode {
-	case config.ExternalMode:
-		e = externalResolver{}
-	case config.VendorMode:
-		e = vendoredResolver{}
+	case config.ExternalMode, config.VendorMode, config.HybridMode:
 	default:
 		return nil
 	}
 
+	gc := getGoConfig(c)
+	names := gc.names
+	if names == nil {
+		names = newNamingIndex(c)
+	}
+	mocks := gc.mockIndex
+	if mocks == nil {
+		mocks = newMockIndex(c)
+	}
+
+	v := gc.vendored
+	if v == nil {
+		v = newVendoredResolver(c.RepoRoot, externalResolver{}, names.libraryName)
+	}
+
+	r := structuredResolver{goPrefix: c.GoPrefix, libName: names.libraryName}
+	e := externalResolver{}
+	n := nestedResolver{repos: c.NestedRepos}
+
+	// repoDir returns the slash-separated directory where the package
+	// "importpath" would be in the repository or a repository nested inside
+	// it. false is returned if importpath is not in either.
+	repoDir := func(importpath string) (string, bool) {
+		if nr, ok := n.match(importpath); ok {
+			return path.Join(nr.Rel, strings.TrimPrefix(strings.TrimPrefix(importpath, nr.GoPrefix), "/")), true
+		}
+		if importpath == c.GoPrefix {
+			return "", true
+		}
+		if strings.HasPrefix(importpath, c.GoPrefix+"/") {
+			return strings.TrimPrefix(importpath, c.GoPrefix+"/"), true
+		}
+		return "", false
+	}
+
+	// packageDir returns the slash-separated directory of the package
+	// "importpath", imported from "dir", if the package is in the
+	// repository. Vendored packages and import comments are found the same
+	// way they are when imports are resolved below. The directory might
+	// not exist.
+	packageDir := func(importpath, dir string) (string, bool) {
+		if packages.IsVendored(dir) || c.DepModeFor(importpath) != config.ExternalMode {
+			if l, ok := v.lookup(importpath, dir); ok {
+				if l.relative {
+					return dir, true
+				}
+				return l.pkg, true
+			}
+		}
+		if rel, ok := c.ImportComments[importpath]; ok {
+			return rel, true
+		}
+		if _, ok := n.match(importpath); ok {
+			return "", false
+		}
+		return repoDir(importpath)
+	}
+
 	return &generator{
-		c: c,
+		c:  c,
+		gc: gc,
 		r: resolverFunc(func(importpath, dir string) (label, error) {
-			if importpath != c.GoPrefix && !strings.HasPrefix(importpath, c.GoPrefix+"/") && !isRelative(importpath) {
+			if packages.IsVendored(dir) && !isRelative(importpath) {
+				// Vendored packages are resolved in the vendor tree first, regardless
+				// of the dependency mode, since that's what the go command does.
+				if l, ok := v.lookup(importpath, dir); ok {
+					return l, nil
+				}
+			}
+			if rel, ok := c.ImportComments[importpath]; ok {
+				// The package declares its import path in an import comment.
+				if rel == dir {
+					return label{name: names.libraryName(rel), relative: true}, nil
+				}
+				return label{pkg: rel, name: names.libraryName(rel)}, nil
+			}
+			rel, inRepo := repoDir(importpath)
+			if inRepo && c.DepModeFor(importpath) != config.ExternalMode {
+				// The go command prefers vendored packages over packages in the
+				// repository with the same import path, so we do too.
+				if l, ok := v.lookup(importpath, dir); ok {
+					if rel != "" && v.isPackage(rel) {
+						log.Printf("%s: vendored package %q in //%s shadows a package in the repository", dir, importpath, l.pkg)
+					}
+					return l, nil
+				}
+			}
+			if _, ok := n.match(importpath); ok {
+				return n.resolve(importpath, dir)
+			}
+			if l, ok := mocks.lookup(importpath, dir, packageDir); ok {
+				return l, nil
+			}
+			if inRepo || isRelative(importpath) {
+				return r.resolve(importpath, dir)
+			}
+
+			switch c.DepModeFor(importpath) {
+			case config.ExternalMode:
 				return e.resolve(importpath, dir)
+			case config.VendorMode:
+				// Unlike hybrid mode, vendored mode never resolves imports to
+				// go_repository rules. Packages that aren't vendored anywhere
+				// are expected in the root vendor directory.
+				if l, ok := v.lookup(importpath, dir); ok {
+					return l, nil
+				}
+				log.Printf("%s: package %q is not vendored; resolving it in //vendor", dir, importpath)
+				pkg := path.Join("vendor", importpath)
+				return label{pkg: pkg, name: names.libraryName(pkg)}, nil
+			default:
+				return v.resolve(importpath, dir)
 			}
-			return r.resolve(importpath, dir)
 		}),
 	}
 }
 
 type generator struct {
-	c *config.Config
-	r labelResolver
+	c  *config.Config
+	gc *goConfig
+	r  labelResolver
 }
 
 func (g *generator) Generate(pkg *packages.Package) *bzl.File {
 	f := &bzl.File{
 		Path: filepath.Join(pkg.Dir, g.c.DefaultBuildFileName()),
 	}
-	rs := g.generateRules(pkg)
-	if load := g.generateLoad(rs); load != nil {
-		f.Stmt = append(f.Stmt, load)
+	rs, imports := g.generateRules(pkg)
+	for i, r := range rs {
+		g.resolve(pkg.Rel, r, imports[i])
 	}
+	f.Stmt = append(f.Stmt, lang.GenerateLoads(goLoads, rs)...)
 	for _, r := range rs {
 		f.Stmt = append(f.Stmt, r.Call)
 	}
 	return f
 }
 
-func (g *generator) generateRules(pkg *packages.Package) []*bzl.Rule {
+// generateRules generates rules for the targets in "pkg". It returns a list
+// of rules and a parallel list of imports for each rule. Each element of
+// imports is either nil or a packages.PlatformStrings.
+func (g *generator) generateRules(pkg *packages.Package) ([]*bzl.Rule, []interface{}) {
 	var rules []*bzl.Rule
+	var imports []interface{}
+	add := func(r *bzl.Rule, imps interface{}) {
+		rules = append(rules, r)
+		imports = append(imports, imps)
+	}
+
 	if pkg.Rel == "" {
-		rules = append(rules, newRule("go_prefix", []interface{}{g.c.GoPrefix}, nil))
+		add(newRule("go_prefix", []interface{}{g.c.GoPrefix}, nil), nil)
+	}
+
+	genrules, pkg := g.generateGoGenerate(pkg)
+
+	ns := g.gc.naming.expand(filepath.Base(pkg.Dir))
+	if pkg.IsCommand() && ns.library == ns.binary {
+		// Commands have both a library and a binary, so they can't share a
+		// name. The library can't be imported, so its name doesn't matter.
+		ns.library += "_lib"
 	}
 
-	cgoLibrary, r := g.generateCgoLib(pkg)
+	cgoLibrary, r := g.generateCgoLib(pkg, ns)
 	if r != nil {
-		rules = append(rules, r)
+		add(r, pkg.CgoLibrary.Imports)
 	}
 
-	library, r := g.generateLib(pkg, cgoLibrary)
+	library, r := g.generateLib(pkg, ns, cgoLibrary)
 	if r != nil {
-		rules = append(rules, r)
+		add(r, pkg.Library.Imports)
 	}
 
-	if r := g.generateBin(pkg, library); r != nil {
-		rules = append(rules, r)
+	if r := g.generateBin(pkg, ns, library); r != nil {
+		add(r, pkg.Binary.Imports)
 	}
 
-	if r := g.filegroup(pkg); r != nil {
-		rules = append(rules, r)
+	if r := g.filegroup(pkg, ns); r != nil {
+		add(r, nil)
 	}
 
-	if r := g.generateTest(pkg, library); r != nil {
-		rules = append(rules, r)
+	for _, r := range genrules {
+		add(r, nil)
 	}
 
-	if r := g.generateXTest(pkg, library); r != nil {
-		rules = append(rules, r)
+	mockRules, mockImports := g.generateMocks(pkg, library)
+	for i, r := range mockRules {
+		add(r, mockImports[i])
 	}
 
-	return rules
+	if g.gc.combineTests && library != "" && pkg.XTest.HasGo() {
+		add(g.generateCombinedTest(pkg, ns, library))
+	} else {
+		if r := g.generateTest(pkg, ns, library); r != nil {
+			add(r, pkg.Test.Imports)
+		}
+
+		if r := g.generateXTest(pkg, ns, library); r != nil {
+			add(r, pkg.XTest.Imports)
+		}
+	}
+
+	return rules, imports
 }
 
-func (g *generator) generateBin(pkg *packages.Package, library string) *bzl.Rule {
+// resolve converts imports returned by generateRules into labels and sets
+// the "deps" attribute of "r". It returns the dependencies of r on other
+// targets in the repository.
+func (g *generator) resolve(rel string, r *bzl.Rule, imports interface{}) []graph.Edge {
+	imps, ok := imports.(packages.PlatformStrings)
+	if !ok || imps.IsEmpty() {
+		return nil
+	}
+	deps, edges := g.dependencies(imps, rel, r.Name())
+	r.SetAttr("deps", newValue(deps))
+	return edges
+}
+
+func (g *generator) generateBin(pkg *packages.Package, ns names, library string) *bzl.Rule {
 	if !pkg.IsCommand() || pkg.Binary.Sources.IsEmpty() && library == "" {
 		return nil
 	}
-	name := filepath.Base(pkg.Dir)
-	visibility := checkInternalVisibility(pkg.Rel, "//visibility:public")
-	return g.generateRule(pkg.Rel, "go_binary", name, visibility, library, false, pkg.Binary)
+	name := ns.binary
+	visibility := g.visibility(pkg.Rel)
+	return g.generateRule("go_binary", name, visibility, library, "", false, pkg.Binary)
 }
 
-func (g *generator) generateLib(pkg *packages.Package, cgoName string) (string, *bzl.Rule) {
+func (g *generator) generateLib(pkg *packages.Package, ns names, cgoName string) (string, *bzl.Rule) {
 	if !pkg.Library.HasGo() && cgoName == "" {
 		return "", nil
 	}
 
-	name := defaultLibName
-	var visibility string
+	name := ns.library
+	var visibility []string
 	if pkg.IsCommand() {
 		// Libraries made for a go_binary should not be exposed to the public.
-		visibility = "//visibility:private"
+		visibility = []string{privateVisibility}
 	} else {
-		visibility = checkInternalVisibility(pkg.Rel, "//visibility:public")
+		visibility = g.visibility(pkg.Rel)
 	}
 
-	rule := g.generateRule(pkg.Rel, "go_library", name, visibility, cgoName, false, pkg.Library)
+	var importpath string
+	if packages.IsVendored(pkg.Rel) || name != defaultLibName || pkg.ImportPath(g.c.GoPrefix) != packages.ImportPath(g.c.GoPrefix, pkg.Rel) {
+		// The import path of a vendored package, a library with a non-default
+		// name, or a package with an import comment that declares a different
+		// path can't be derived from go_prefix and the package's label, so
+		// set it explicitly.
+		importpath = pkg.ImportPath(g.c.GoPrefix)
+	}
+	rule := g.generateRule("go_library", name, visibility, cgoName, importpath, false, pkg.Library)
 	return name, rule
 }
 
-func (g *generator) generateCgoLib(pkg *packages.Package) (string, *bzl.Rule) {
+func (g *generator) generateCgoLib(pkg *packages.Package, ns names) (string, *bzl.Rule) {
 	if !pkg.CgoLibrary.HasGo() {
 		return "", nil
 	}
 
-	name := defaultCgoLibName
-	visibility := "//visibility:private"
-	rule := g.generateRule(pkg.Rel, "cgo_library", name, visibility, "", false, pkg.CgoLibrary)
+	name := ns.cgoLibrary
+	visibility := []string{privateVisibility}
+	rule := g.generateRule("cgo_library", name, visibility, "", "", false, pkg.CgoLibrary)
 	return name, rule
 }
 
-// checkInternalVisibility overrides the given visibility if the package is
-// internal.
-func checkInternalVisibility(rel, visibility string) string {
-	if i := strings.LastIndex(rel, "/internal/"); i >= 0 {
-		visibility = fmt.Sprintf("//%s:__subpackages__", rel[:i])
-	} else if strings.HasPrefix(rel, "internal/") {
-		visibility = "//:__subpackages__"
+// visibility returns the visibility of libraries and binaries in the
+// package "rel". This is the default visibility configured with the
+// gazelle:default_visibility directive, or public if none was set. Public
+// packages in internal or vendor directories are restricted to the
+// subtree the go command allows to import them.
+func (g *generator) visibility(rel string) []string {
+	vis := g.gc.defaultVisibility
+	if len(vis) == 0 {
+		vis = []string{publicVisibility}
+	}
+	if len(vis) == 1 && vis[0] == publicVisibility {
+		if restricted := restrictedVisibility(rel); restricted != "" {
+			return []string{restricted}
+		}
+	}
+	return vis
+}
+
+// restrictedVisibility returns a visibility that allows packages in the
+// subtree the go command allows to import the package "rel", or "" if any
+// package may import it. Packages in an internal directory may only be
+// imported from the directory containing it; the same is true for vendor
+// directories. When there are several of these directories, the innermost
+// one is most restrictive.
+func restrictedVisibility(rel string) string {
+	if rel == "" {
+		return ""
+	}
+	parts := strings.Split(rel, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i] == "internal" || parts[i] == "vendor" {
+			return fmt.Sprintf("//%s:__subpackages__", strings.Join(parts[:i], "/"))
+		}
 	}
-	return visibility
+	return ""
 }
 
 // filegroup is a small hack for directories with pre-generated .pb.go files
 // and also source .proto files.  This creates a filegroup for the .proto in
 // addition to the usual go_library for the .pb.go files.
-func (g *generator) filegroup(pkg *packages.Package) *bzl.Rule {
+func (g *generator) filegroup(pkg *packages.Package, ns names) *bzl.Rule {
 	if !pkg.HasPbGo || len(pkg.Protos) == 0 {
 		return nil
 	}
 	return newRule("filegroup", nil, []keyvalue{
-		{key: "name", value: defaultProtosName},
+		{key: "name", value: ns.library + protosSuffix},
 		{key: "srcs", value: pkg.Protos},
-		{key: "visibility", value: []string{"//visibility:public"}},
+		{key: "visibility", value: g.visibility(pkg.Rel)},
 	})
 }
 
-func (g *generator) generateTest(pkg *packages.Package, library string) *bzl.Rule {
+func (g *generator) generateTest(pkg *packages.Package, ns names, library string) *bzl.Rule {
 	if !pkg.Test.HasGo() {
 		return nil
 	}
+	r := g.generateRule("go_test", ns.test, nil, library, "", pkg.HasTestdata, pkg.Test)
+	g.setTestAttrs(r, pkg.Test)
+	return r
+}
 
-	var name string
-	if library == "" || library == defaultLibName {
-		name = defaultTestName
-	} else {
-		name = library + "_test"
+func (g *generator) generateXTest(pkg *packages.Package, ns names, library string) *bzl.Rule {
+	if !pkg.XTest.HasGo() {
+		return nil
+	}
+	r := g.generateRule("go_test", ns.xtest, nil, "", "", pkg.HasTestdata, pkg.XTest)
+	g.setTestAttrs(r, pkg.XTest)
+	return r
+}
+
+// generateCombinedTest generates a single go_test rule containing both the
+// internal and external test sources of "pkg", as "go test" builds them.
+// External test sources are listed in "xtest_srcs" and may use helpers
+// defined in internal test files. It returns the rule and its imports,
+// which don't include the package under test.
+func (g *generator) generateCombinedTest(pkg *packages.Package, ns names, library string) (*bzl.Rule, packages.PlatformStrings) {
+	attrs := []keyvalue{{"name", ns.test}}
+	if !pkg.Test.Sources.IsEmpty() {
+		attrs = append(attrs, keyvalue{"srcs", pkg.Test.Sources})
+	}
+	if pkg.HasTestdata {
+		glob := globvalue{patterns: []string{"testdata/**"}}
+		attrs = append(attrs, keyvalue{"data", glob})
 	}
+	attrs = append(attrs, keyvalue{"library", ":" + library})
+	attrs = append(attrs, keyvalue{"xtest_srcs", pkg.XTest.Sources})
 
-	return g.generateRule(pkg.Rel, "go_test", name, "", library, pkg.HasTestdata, pkg.Test)
+	imports := combineImports(pkg.Test.Imports, pkg.XTest.Imports, pkg.ImportPath(g.c.GoPrefix))
+	r := newRule("go_test", nil, attrs)
+	g.setTestAttrs(r, pkg.Test, pkg.XTest)
+	return r, imports
 }
 
-func (g *generator) generateXTest(pkg *packages.Package, library string) *bzl.Rule {
-	if !pkg.XTest.HasGo() {
-		return nil
+// setTestAttrs sets the size, timeout, shard_count, and tags attributes of
+// the test rule "r", built from "targets". Values come from directives.
+// Tests that call testing.Short are assumed to have slow cases, so they are
+// "medium" unless a size was configured. Tests are tagged "manual" if all
+// of their sources require one of the manual build tags; tests that also
+// have other sources are not, so those sources still run with bazel test.
+func (g *generator) setTestAttrs(r *bzl.Rule, targets ...packages.Target) {
+	size := g.gc.testSize
+	tags := append([]string{}, g.gc.testTags...)
+	hasGo, requiresTestTags := false, true
+	for _, t := range targets {
+		if size == "" && t.CallsTestingShort {
+			size = "medium"
+		}
+		if t.HasGo() {
+			hasGo = true
+			requiresTestTags = requiresTestTags && t.RequiresTestTags
+		}
+	}
+	if hasGo && requiresTestTags {
+		tags = append(tags, "manual")
 	}
 
-	var name string
-	if library == "" || library == defaultLibName {
-		name = defaultXTestName
-	} else {
-		name = library + "_xtest"
+	if size != "" {
+		r.SetAttr("size", newValue(size))
 	}
+	if g.gc.testTimeout != "" {
+		r.SetAttr("timeout", newValue(g.gc.testTimeout))
+	}
+	if g.gc.testShardCount > 0 {
+		r.SetAttr("shard_count", newValue(g.gc.testShardCount))
+	}
+	if len(tags) > 0 {
+		sort.Strings(tags)
+		r.SetAttr("tags", newValue(uniqStrings(tags)))
+	}
+}
 
-	return g.generateRule(pkg.Rel, "go_test", name, "", "", pkg.HasTestdata, pkg.XTest)
+// uniqStrings removes adjacent duplicates from the sorted list "ss".
+func uniqStrings(ss []string) []string {
+	var result []string
+	for i, s := range ss {
+		if i == 0 || s != ss[i-1] {
+			result = append(result, s)
+		}
+	}
+	return result
 }
 
-func (g *generator) generateRule(rel, kind, name, visibility, library string, hasTestdata bool, target packages.Target) *bzl.Rule {
+// combineImports returns the union of "a" and "b" without "self".
+func combineImports(a, b packages.PlatformStrings, self string) packages.PlatformStrings {
+	var result packages.PlatformStrings
+	for _, ps := range []packages.PlatformStrings{a, b} {
+		for _, imp := range ps.Generic {
+			if imp != self {
+				result.Generic = append(result.Generic, imp)
+			}
+		}
+		for n, imps := range ps.Platform {
+			for _, imp := range imps {
+				if imp == self {
+					continue
+				}
+				if result.Platform == nil {
+					result.Platform = make(map[string][]string)
+				}
+				result.Platform[n] = append(result.Platform[n], imp)
+			}
+		}
+		for imp, file := range ps.Files {
+			if _, ok := result.Files[imp]; ok || imp == self {
+				continue
+			}
+			if result.Files == nil {
+				result.Files = make(map[string]string)
+			}
+			result.Files[imp] = file
+		}
+	}
+	result.Clean()
+	return result
+}
+
+func (g *generator) generateRule(kind, name string, visibility []string, library, importpath string, hasTestdata bool, target packages.Target) *bzl.Rule {
 	// Construct attrs in the same order that bzl.Rewrite uses. See
 	// namePriority in github.com/bazelbuild/buildtools/build/rewrite.go.
 	attrs := []keyvalue{
@@ -253,54 +537,42 @@ func (g *generator) generateRule(rel, kind, name, visibility, library string, ha
 	if library != "" {
 		attrs = append(attrs, keyvalue{"library", ":" + library})
 	}
-	if visibility != "" {
-		attrs = append(attrs, keyvalue{"visibility", []string{visibility}})
+	if importpath != "" {
+		attrs = append(attrs, keyvalue{"importpath", importpath})
 	}
-	if !target.Imports.IsEmpty() {
-		deps := g.dependencies(target.Imports, rel)
-		attrs = append(attrs, keyvalue{"deps", deps})
+	if len(visibility) > 0 {
+		attrs = append(attrs, keyvalue{"visibility", visibility})
 	}
 	return newRule(kind, nil, attrs)
 }
 
-func (g *generator) generateLoad(rs []*bzl.Rule) bzl.Expr {
-	loadableKinds := []string{
-		// keep sorted
-		"cgo_library",
-		"go_binary",
-		"go_library",
-		"go_prefix",
-		"go_test",
-	}
-
-	kinds := make(map[string]bool)
-	for _, r := range rs {
-		kinds[r.Kind()] = true
-	}
-	args := make([]bzl.Expr, 0, len(kinds)+1)
-	args = append(args, &bzl.StringExpr{Value: goRulesBzl})
-	for _, k := range loadableKinds {
-		if kinds[k] {
-			args = append(args, &bzl.StringExpr{Value: k})
-		}
-	}
-	if len(args) == 1 {
-		return nil
-	}
-	return &bzl.CallExpr{
-		X:            &bzl.LiteralExpr{Token: "load"},
-		List:         args,
-		ForceCompact: true,
-	}
-}
-
-func (g *generator) dependencies(imports packages.PlatformStrings, dir string) packages.PlatformStrings {
+// dependencies resolves the imports of the rule named "name" in the
+// directory "dir" into labels. Dependencies on targets in the repository
+// are also returned as graph edges.
+func (g *generator) dependencies(imports packages.PlatformStrings, dir, name string) (packages.PlatformStrings, []graph.Edge) {
+	var edges []graph.Edge
 	resolve := func(imp string) (string, error) {
-		if l, err := g.r.resolve(imp, dir); err != nil {
+		l, err := g.r.resolve(imp, dir)
+		if err != nil {
 			return "", fmt.Errorf("in dir %q, could not resolve import path %q: %v", dir, imp, err)
-		} else {
-			return l.String(), nil
 		}
+		if l.repo == "" {
+			var file string
+			if base, ok := imports.Files[imp]; ok {
+				file = path.Join(dir, base)
+			}
+			to := l
+			if to.relative {
+				to = label{pkg: dir, name: l.name}
+			}
+			edges = append(edges, graph.Edge{
+				From:   fmt.Sprintf("//%s:%s", dir, name),
+				To:     fmt.Sprintf("//%s:%s", to.pkg, to.name),
+				Import: imp,
+				File:   file,
+			})
+		}
+		return l.String(), nil
 	}
 
 	deps, errors := imports.Map(resolve)
@@ -308,7 +580,7 @@ func (g *generator) dependencies(imports packages.PlatformStrings, dir string) p
 		log.Print(err)
 	}
 	deps.Clean()
-	return deps
+	return deps, edges
 }
 
 // isRelative determines if an importpath is relative.
@@ -66,6 +66,7 @@ func TestGenerator(t *testing.T) {
 		"platforms",
 		"tests_import_testdata",
 		"tests_with_testdata",
+		"vendor/example.com/vendored/a",
 	} {
 		dir := filepath.Join(repoRoot, filepath.FromSlash(rel))
 		pkg := packageFromDir(c, dir)
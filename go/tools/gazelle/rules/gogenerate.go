@@ -0,0 +1,474 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+)
+
+// Labels of the tools run by genrules generated for //go:generate comments.
+// These are the labels Gazelle generates for the tools' repositories when
+// they are declared with go_repository.
+const (
+	stringerLabel    = "@org_golang_x_tools//cmd/stringer"
+	mockgenLabel     = "@com_github_golang_mock//mockgen"
+	protocLabel      = "@com_google_protobuf//:protoc"
+	protocGenGoLabel = "@com_github_golang_protobuf//protoc-gen-go"
+	goBindataLabel   = "@com_github_jteeuwen_go_bindata//go-bindata"
+)
+
+// genrule describes a genrule that produces Go sources in place of a
+// //go:generate comment.
+type genrule struct {
+	srcs  interface{}
+	outs  []string
+	cmd   string
+	tools []string
+
+	// imports are packages imported by the generated sources.
+	imports []string
+
+	// xtest is true if the generated sources are in the external test
+	// package.
+	xtest bool
+}
+
+// goGenerators maps the names of commands that Gazelle recognizes in
+// //go:generate comments to functions that convert their arguments to
+// genrules. Each function returns an error describing why the command
+// can't be converted if it isn't supported.
+var goGenerators = map[string]func(pkg *packages.Package, d packages.GenerateDirective, args []string) (genrule, error){
+	"stringer":   stringerGenrule,
+	"mockgen":    mockgenGenrule,
+	"protoc":     protocGenrule,
+	"go-bindata": goBindataGenrule,
+}
+
+// generateGoGenerate converts the //go:generate comments in "pkg" into
+// genrules. Commands whose outputs are already checked in are skipped.
+// It returns the genrules and a copy of "pkg" with the generated sources
+// and their imports added to the library and test targets. Unrecognized
+// commands are reported.
+func (g *generator) generateGoGenerate(pkg *packages.Package) ([]*bzl.Rule, *packages.Package) {
+	if len(pkg.Generates) == 0 {
+		return nil, pkg
+	}
+	p := *pkg
+	var rules []*bzl.Rule
+	for _, d := range pkg.Generates {
+		name, args := d.Args[0], d.Args[1:]
+		if name == "go" && len(args) >= 2 && args[0] == "run" && !strings.HasSuffix(args[1], ".go") {
+			// Commands may be run by import path, for example,
+			// "go run github.com/golang/mock/mockgen".
+			name, args = path.Base(args[1]), args[2:]
+		}
+		convert, ok := goGenerators[name]
+		if !ok {
+			log.Printf("%s: go:generate command %q is not supported; generated files must be checked in", path.Join(pkg.Rel, d.File), name)
+			continue
+		}
+		gr, err := convert(pkg, d, args)
+		if err != nil {
+			log.Printf("%s: go:generate %s: %v; generated files must be checked in", path.Join(pkg.Rel, d.File), name, err)
+			continue
+		}
+		if allExist(pkg.Dir, gr.outs) {
+			continue
+		}
+		rules = append(rules, newRule("genrule", nil, []keyvalue{
+			{"name", genruleName(gr.outs[0])},
+			{"srcs", gr.srcs},
+			{"outs", gr.outs},
+			{"cmd", gr.cmd},
+			{"tools", gr.tools},
+		}))
+		for _, out := range gr.outs {
+			t := &p.Library
+			if gr.xtest {
+				t = &p.XTest
+			} else if strings.HasSuffix(out, "_test.go") {
+				t = &p.Test
+			}
+			t.Sources = appendGeneric(t.Sources, out)
+			t.Imports = appendGeneric(t.Imports, gr.imports...)
+		}
+	}
+	return rules, &p
+}
+
+// appendGeneric returns a copy of "ps" with "ss" added to the generic list.
+// "ps" is not modified.
+func appendGeneric(ps packages.PlatformStrings, ss ...string) packages.PlatformStrings {
+	ps.Generic = append(append([]string{}, ps.Generic...), ss...)
+	ps.Clean()
+	return ps
+}
+
+// allExist returns whether all of the files named "outs" exist in "dir".
+func allExist(dir string, outs []string) bool {
+	for _, out := range outs {
+		if _, err := os.Stat(filepath.Join(dir, out)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// genruleName returns the name of a genrule that produces the file "out".
+func genruleName(out string) string {
+	return strings.Replace(strings.TrimSuffix(out, ".go"), ".", "_", -1)
+}
+
+// commandFlag is a flag in a command line.
+type commandFlag struct {
+	name, value string
+	hasValue    bool
+}
+
+func (f commandFlag) String() string {
+	if !f.hasValue {
+		return "-" + f.name
+	}
+	return "-" + f.name + "=" + shellQuote(f.value)
+}
+
+// parseFlags splits "args" into flags and positional arguments, following
+// the conventions of the flag package. Flags in "boolFlags" don't take a
+// separate value. Flags not in "known" are reported as errors.
+func parseFlags(args []string, known, boolFlags map[string]bool) ([]commandFlag, []string, error) {
+	var flags []commandFlag
+	for len(args) > 0 {
+		a := args[0]
+		if a == "--" {
+			return flags, args[1:], nil
+		}
+		if len(a) < 2 || a[0] != '-' {
+			break
+		}
+		args = args[1:]
+		f := commandFlag{name: strings.TrimLeft(a, "-")}
+		if i := strings.Index(f.name, "="); i >= 0 {
+			f.name, f.value, f.hasValue = f.name[:i], f.name[i+1:], true
+		}
+		if !known[f.name] && !boolFlags[f.name] {
+			return nil, nil, fmt.Errorf("unknown flag %q", a)
+		}
+		if !f.hasValue && !boolFlags[f.name] {
+			if len(args) == 0 {
+				return nil, nil, fmt.Errorf("flag %q needs a value", a)
+			}
+			f.value, f.hasValue, args = args[0], true, args[1:]
+		}
+		flags = append(flags, f)
+	}
+	return flags, args, nil
+}
+
+// flagValue returns the value of the last flag named "name" in "flags", or
+// "" if there is none.
+func flagValue(flags []commandFlag, name string) string {
+	v := ""
+	for _, f := range flags {
+		if f.name == name {
+			v = f.value
+		}
+	}
+	return v
+}
+
+// joinFlags formats "flags" for a genrule command, leaving out flags named
+// in "skip".
+func joinFlags(flags []commandFlag, skip ...string) string {
+	var parts []string
+outer:
+	for _, f := range flags {
+		for _, s := range skip {
+			if f.name == s {
+				continue outer
+			}
+		}
+		parts = append(parts, f.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// shellQuote quotes "s" for the shell if it contains special characters.
+// "$" is escaped for Bazel's make variable substitution.
+func shellQuote(s string) string {
+	s = strings.Replace(s, "$", "$$", -1)
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\|&;<>()*?[]{}`!#~") {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// localFile checks that "name" is a file in the package directory and
+// returns its cleaned name.
+func localFile(name string) (string, error) {
+	clean := path.Clean(name)
+	if strings.Contains(clean, "/") || clean == "." || clean == ".." {
+		return "", fmt.Errorf("%q is not in the package directory", name)
+	}
+	return clean, nil
+}
+
+// checkPackageName checks that a generated file declares the package
+// "name", which must be the package being built or its external test
+// package (if "out" is a test). It returns whether the file is in the
+// external test package.
+func checkPackageName(pkg *packages.Package, out, name string) (xtest bool, err error) {
+	if name == pkg.Name {
+		return false, nil
+	}
+	if strings.HasSuffix(out, "_test.go") && name == pkg.Name+"_test" {
+		return true, nil
+	}
+	return false, fmt.Errorf("%s would be in package %q, not %q", out, name, pkg.Name)
+}
+
+// libraryGoSources returns the .go files in the library of "pkg" that are
+// built on all platforms.
+func libraryGoSources(pkg *packages.Package) []string {
+	var srcs []string
+	for _, s := range pkg.Library.Sources.Generic {
+		if strings.HasSuffix(s, ".go") {
+			srcs = append(srcs, s)
+		}
+	}
+	return srcs
+}
+
+// stringerGenrule converts a stringer command. stringer must be run on the
+// package in the current directory.
+func stringerGenrule(pkg *packages.Package, d packages.GenerateDirective, args []string) (genrule, error) {
+	flags, pos, err := parseFlags(args,
+		map[string]bool{"type": true, "output": true, "trimprefix": true, "tags": true},
+		map[string]bool{"linecomment": true})
+	if err != nil {
+		return genrule{}, err
+	}
+	if len(pos) > 1 || len(pos) == 1 && pos[0] != "." {
+		return genrule{}, fmt.Errorf("only the package in the current directory is supported")
+	}
+	types := flagValue(flags, "type")
+	if types == "" {
+		return genrule{}, fmt.Errorf("-type must be set")
+	}
+	out := flagValue(flags, "output")
+	if out == "" {
+		out = strings.ToLower(strings.Split(types, ",")[0]) + "_string.go"
+	}
+	if out, err = localFile(out); err != nil {
+		return genrule{}, err
+	}
+	srcs := libraryGoSources(pkg)
+	if len(srcs) == 0 {
+		return genrule{}, fmt.Errorf("no library sources")
+	}
+	return genrule{
+		srcs:  srcs,
+		outs:  []string{out},
+		cmd:   fmt.Sprintf("$(location %s) %s -output $@ $(SRCS)", stringerLabel, joinFlags(flags, "output")),
+		tools: []string{stringerLabel},
+	}, nil
+}
+
+// mockgenGenrule converts a mockgen command. Only source mode is supported,
+// since reflect mode needs to build the package being mocked.
+func mockgenGenrule(pkg *packages.Package, d packages.GenerateDirective, args []string) (genrule, error) {
+	flags, pos, err := parseFlags(args,
+		map[string]bool{"source": true, "destination": true, "package": true, "imports": true, "self_package": true, "mock_names": true, "copyright_file": true},
+		map[string]bool{"write_package_comment": true})
+	if err != nil {
+		return genrule{}, err
+	}
+	source := flagValue(flags, "source")
+	if source == "" || len(pos) > 0 {
+		return genrule{}, fmt.Errorf("only source mode is supported")
+	}
+	if source, err = localFile(source); err != nil {
+		return genrule{}, err
+	}
+	out := flagValue(flags, "destination")
+	if out == "" {
+		return genrule{}, fmt.Errorf("-destination must be set")
+	}
+	if out, err = localFile(out); err != nil {
+		return genrule{}, err
+	}
+	name := flagValue(flags, "package")
+	if name == "" {
+		name = "mock_" + pkg.Name
+	}
+	xtest, err := checkPackageName(pkg, out, name)
+	if err != nil {
+		return genrule{}, err
+	}
+	return genrule{
+		srcs:    []string{source},
+		outs:    []string{out},
+		cmd:     strings.TrimSpace(fmt.Sprintf("$(location %s) -source=$(location %s) -destination=$@ %s", mockgenLabel, source, joinFlags(flags, "source", "destination"))),
+		tools:   []string{mockgenLabel},
+		imports: []string{"github.com/golang/mock/gomock"},
+		xtest:   xtest,
+	}, nil
+}
+
+// protocGenrule converts a protoc command which generates Go code for
+// .proto files in the package directory.
+func protocGenrule(pkg *packages.Package, d packages.GenerateDirective, args []string) (genrule, error) {
+	var goOut string
+	var protos []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case strings.HasPrefix(a, "--go_out="):
+			goOut = strings.TrimPrefix(a, "--go_out=")
+		case a == "-I" || a == "--proto_path":
+			if i+1 < len(args) && path.Clean(args[i+1]) != "." {
+				return genrule{}, fmt.Errorf("only the package directory is supported as an import path")
+			}
+			i++
+		case strings.HasPrefix(a, "-I") || strings.HasPrefix(a, "--proto_path="):
+			p := strings.TrimPrefix(strings.TrimPrefix(a, "-I"), "--proto_path=")
+			if path.Clean(p) != "." {
+				return genrule{}, fmt.Errorf("only the package directory is supported as an import path")
+			}
+		case strings.HasPrefix(a, "-"):
+			return genrule{}, fmt.Errorf("unsupported flag %q", a)
+		default:
+			p, err := localFile(a)
+			if err != nil {
+				return genrule{}, err
+			}
+			protos = append(protos, p)
+		}
+	}
+	if goOut == "" {
+		return genrule{}, fmt.Errorf("--go_out must be set")
+	}
+	params, dir := "", goOut
+	if i := strings.LastIndex(goOut, ":"); i >= 0 {
+		params, dir = goOut[:i], goOut[i+1:]
+	}
+	if path.Clean(dir) != "." {
+		return genrule{}, fmt.Errorf("only the package directory is supported as an output directory")
+	}
+	if len(protos) == 0 {
+		return genrule{}, fmt.Errorf("no .proto files")
+	}
+
+	var outs []string
+	for _, p := range protos {
+		outs = append(outs, strings.TrimSuffix(p, ".proto")+".pb.go")
+	}
+	imports := []string{"github.com/golang/protobuf/proto"}
+	for _, p := range strings.Split(params, ",") {
+		if p == "plugins=grpc" {
+			imports = append(imports, "golang.org/x/net/context", "google.golang.org/grpc")
+		}
+	}
+	if params != "" {
+		params += ":"
+	}
+	return genrule{
+		srcs:    protos,
+		outs:    outs,
+		cmd:     fmt.Sprintf("$(location %s) --plugin=protoc-gen-go=$(location %s) -I$$(dirname $(location %s)) --go_out=%s$(@D) $(SRCS)", protocLabel, protocGenGoLabel, protos[0], shellQuote(params)),
+		tools:   []string{protocLabel, protocGenGoLabel},
+		imports: imports,
+	}, nil
+}
+
+// goBindataGenrule converts a go-bindata command. Inputs must be in the
+// package directory or its subdirectories.
+func goBindataGenrule(pkg *packages.Package, d packages.GenerateDirective, args []string) (genrule, error) {
+	flags, pos, err := parseFlags(args,
+		map[string]bool{"o": true, "pkg": true, "prefix": true, "ignore": true, "tags": true, "mode": true, "modtime": true},
+		map[string]bool{"debug": true, "dev": true, "nomemcopy": true, "nocompress": true, "nometadata": true, "fs": true})
+	if err != nil {
+		return genrule{}, err
+	}
+	out := flagValue(flags, "o")
+	if out == "" {
+		out = "bindata.go"
+	}
+	if out, err = localFile(out); err != nil {
+		return genrule{}, err
+	}
+	name := flagValue(flags, "pkg")
+	if name == "" {
+		name = "main"
+	}
+	xtest, err := checkPackageName(pkg, out, name)
+	if err != nil {
+		return genrule{}, err
+	}
+	if len(pos) == 0 {
+		return genrule{}, fmt.Errorf("no inputs")
+	}
+
+	var patterns, files, inputs []string
+	for _, p := range pos {
+		recursive := strings.HasSuffix(p, "/...")
+		clean := path.Clean(strings.TrimSuffix(p, "/..."))
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return genrule{}, fmt.Errorf("input %q is not in the package directory", p)
+		}
+		inputs = append(inputs, shellQuote(p))
+		fi, err := os.Stat(filepath.Join(pkg.Dir, filepath.FromSlash(clean)))
+		switch {
+		case err != nil:
+			return genrule{}, err
+		case !fi.IsDir():
+			files = append(files, clean)
+		case recursive:
+			patterns = append(patterns, path.Join(clean, "**"))
+		default:
+			patterns = append(patterns, path.Join(clean, "*"))
+		}
+	}
+	var srcs interface{} = files
+	if len(patterns) > 0 {
+		sort.Strings(patterns)
+		srcs = globvalue{patterns: append(patterns, files...)}
+	}
+
+	dir := pkg.Rel
+	if dir == "" {
+		dir = "."
+	}
+	flagStr := joinFlags(flags, "o")
+	if flagStr != "" {
+		flagStr += " "
+	}
+	return genrule{
+		srcs:  srcs,
+		outs:  []string{out},
+		cmd:   fmt.Sprintf("ROOT=$$PWD && cd %s && $$ROOT/$(location %s) %s-o $$ROOT/$@ %s", shellQuote(dir), goBindataLabel, flagStr, strings.Join(inputs, " ")),
+		tools: []string{goBindataLabel},
+		xtest: xtest,
+	}, nil
+}
@@ -0,0 +1,199 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+)
+
+func TestGoGeneratorCommands(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "assets", "img"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := &packages.Package{
+		Name: "foo",
+		Dir:  dir,
+		Rel:  "foo",
+		Library: packages.Target{
+			Sources: packages.PlatformStrings{Generic: []string{"foo.go", "pill.go"}},
+		},
+	}
+	for _, tc := range []struct {
+		desc, cmd string
+		want      genrule
+		wantErr   string
+	}{
+		{
+			desc: "stringer",
+			cmd:  "stringer -type=Pill,Color",
+			want: genrule{
+				srcs:  []string{"foo.go", "pill.go"},
+				outs:  []string{"pill_string.go"},
+				cmd:   "$(location @org_golang_x_tools//cmd/stringer) -type=Pill,Color -output $@ $(SRCS)",
+				tools: []string{stringerLabel},
+			},
+		}, {
+			desc: "stringer output",
+			cmd:  "stringer -type Pill -output=pills.go -linecomment",
+			want: genrule{
+				srcs:  []string{"foo.go", "pill.go"},
+				outs:  []string{"pills.go"},
+				cmd:   "$(location @org_golang_x_tools//cmd/stringer) -type=Pill -linecomment -output $@ $(SRCS)",
+				tools: []string{stringerLabel},
+			},
+		}, {
+			desc:    "stringer other directory",
+			cmd:     "stringer -type=Pill ../bar",
+			wantErr: "current directory",
+		}, {
+			desc: "mockgen",
+			cmd:  "mockgen -source=foo.go -destination=mock_foo_test.go -package=foo_test",
+			want: genrule{
+				srcs:    []string{"foo.go"},
+				outs:    []string{"mock_foo_test.go"},
+				cmd:     "$(location @com_github_golang_mock//mockgen) -source=$(location foo.go) -destination=$@ -package=foo_test",
+				tools:   []string{mockgenLabel},
+				imports: []string{"github.com/golang/mock/gomock"},
+				xtest:   true,
+			},
+		}, {
+			desc:    "mockgen reflect mode",
+			cmd:     "mockgen example.com/foo Foo",
+			wantErr: "source mode",
+		}, {
+			desc:    "mockgen other package",
+			cmd:     "mockgen -source=foo.go -destination=mock_foo.go",
+			wantErr: `package "mock_foo"`,
+		}, {
+			desc:    "mockgen other directory",
+			cmd:     "mockgen -source=foo.go -destination=mocks/mock_foo.go -package=mocks",
+			wantErr: "not in the package directory",
+		}, {
+			desc: "protoc",
+			cmd:  "protoc --go_out=plugins=grpc:. foo.proto bar.proto",
+			want: genrule{
+				srcs:    []string{"foo.proto", "bar.proto"},
+				outs:    []string{"foo.pb.go", "bar.pb.go"},
+				cmd:     "$(location @com_google_protobuf//:protoc) --plugin=protoc-gen-go=$(location @com_github_golang_protobuf//protoc-gen-go) -I$$(dirname $(location foo.proto)) --go_out=plugins=grpc:$(@D) $(SRCS)",
+				tools:   []string{protocLabel, protocGenGoLabel},
+				imports: []string{"github.com/golang/protobuf/proto", "golang.org/x/net/context", "google.golang.org/grpc"},
+			},
+		}, {
+			desc:    "protoc other language",
+			cmd:     "protoc --java_out=. foo.proto",
+			wantErr: "unsupported flag",
+		}, {
+			desc: "go-bindata",
+			cmd:  "go-bindata -pkg foo -o assets.go -nocompress assets/... foo.go",
+			want: genrule{
+				srcs:  globvalue{patterns: []string{"assets/**", "foo.go"}},
+				outs:  []string{"assets.go"},
+				cmd:   "ROOT=$$PWD && cd foo && $$ROOT/$(location @com_github_jteeuwen_go_bindata//go-bindata) -pkg=foo -nocompress -o $$ROOT/$@ assets/... foo.go",
+				tools: []string{goBindataLabel},
+			},
+		}, {
+			desc:    "go-bindata outside package",
+			cmd:     "go-bindata -pkg foo ../assets",
+			wantErr: "not in the package directory",
+		},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "foo.go"), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		args := strings.Fields(tc.cmd)
+		got, err := goGenerators[args[0]](pkg, packages.GenerateDirective{File: "foo.go", Args: args}, args[1:])
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: got error %v; want error containing %q", tc.desc, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %#v; want %#v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestGenerateGoGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "color_string.go"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := &packages.Package{
+		Name: "foo",
+		Dir:  dir,
+		Rel:  "foo",
+		Library: packages.Target{
+			Sources: packages.PlatformStrings{Generic: []string{"color_string.go", "foo.go"}},
+		},
+		Generates: []packages.GenerateDirective{
+			{File: "foo.go", Args: []string{"stringer", "-type=Pill"}},
+			{File: "foo.go", Args: []string{"stringer", "-type=Color"}},
+			{File: "foo.go", Args: []string{"go", "run", "gen.go"}},
+		},
+	}
+	g := newGenerator(&config.Config{DepMode: config.ExternalMode})
+	rules, got := g.generateGoGenerate(pkg)
+
+	f := &bzl.File{}
+	for _, r := range rules {
+		f.Stmt = append(f.Stmt, r.Call)
+	}
+	want := `genrule(
+    name = "pill_string",
+    srcs = [
+        "color_string.go",
+        "foo.go",
+    ],
+    outs = ["pill_string.go"],
+    cmd = "$(location @org_golang_x_tools//cmd/stringer) -type=Pill -output $@ $(SRCS)",
+    tools = ["@org_golang_x_tools//cmd/stringer"],
+)
+`
+	if s := string(bzl.Format(f)); s != want {
+		t.Errorf("got rules:\n%s\nwant:\n%s", s, want)
+	}
+	if want := []string{"color_string.go", "foo.go", "pill_string.go"}; !reflect.DeepEqual(got.Library.Sources.Generic, want) {
+		t.Errorf("got library sources %q; want %q", got.Library.Sources.Generic, want)
+	}
+	if want := []string{"color_string.go", "foo.go"}; !reflect.DeepEqual(pkg.Library.Sources.Generic, want) {
+		t.Errorf("original package was modified: got sources %q; want %q", pkg.Library.Sources.Generic, want)
+	}
+}
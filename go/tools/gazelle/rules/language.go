@@ -0,0 +1,97 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/graph"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/lang"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+)
+
+// goLoads describes the rule kinds Gazelle generates for Go packages.
+var goLoads = []lang.LoadInfo{
+	{
+		Name: GoRulesBzl,
+		Kinds: []string{
+			"cgo_library",
+			"go_binary",
+			"go_library",
+			"go_prefix",
+			"go_test",
+		},
+	},
+}
+
+// NewLanguage returns a lang.Language which generates rules for Go packages.
+func NewLanguage() lang.Language {
+	return goLanguage{}
+}
+
+type goLanguage struct{}
+
+func (goLanguage) Name() string {
+	return goName
+}
+
+func (goLanguage) Loads() []lang.LoadInfo {
+	return goLoads
+}
+
+func (goLanguage) Configure(c *config.Config, rel string, f *bzl.File) {
+	configure(c, rel, f)
+}
+
+// GenerateRules generates rules for the Go package in "d", if there is one.
+// The repository root always gets a go_prefix rule, even if it does not
+// contain a Go package.
+func (goLanguage) GenerateRules(c *config.Config, d *packages.Dir) ([]*bzl.Rule, []interface{}) {
+	pkg := packages.FindPackage(d)
+	if pkg == nil {
+		if d.Rel != "" {
+			return nil, nil
+		}
+		pkg = &packages.Package{Dir: d.Path}
+	}
+	g := generatorFor(c)
+	if g == nil {
+		return nil, nil
+	}
+	return g.generateRules(pkg)
+}
+
+func (goLanguage) Resolve(c *config.Config, rel string, r *bzl.Rule, imports interface{}) []graph.Edge {
+	if g := generatorFor(c); g != nil {
+		return g.resolve(rel, r, imports)
+	}
+	return nil
+}
+
+// generatorFor returns a generator for the directory configured by "c".
+// The generator is stored in the directory's Go configuration, so rules in
+// the same directory are generated and resolved with the same one. nil is
+// returned if c.DepMode is invalid.
+func generatorFor(c *config.Config) *generator {
+	gc, ok := c.Exts[goName].(*goConfig)
+	if !ok {
+		return newGenerator(c)
+	}
+	if gc.gen == nil || gc.gen.c != c {
+		gc.gen = newGenerator(c)
+	}
+	return gc.gen
+}
@@ -0,0 +1,188 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"fmt"
+	"go/token"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+)
+
+// mockPackage is a package of mocks generated by mockgen, declared with a
+// directive like "# gazelle:mock mocks Reader,Writer" in the build file of
+// the package that defines the interfaces. The mock package has the import
+// path of that package followed by "/" and its name.
+type mockPackage struct {
+	name       string
+	interfaces []string
+}
+
+// parseMockDirective parses the value of a "mock" directive.
+func parseMockDirective(value string) (mockPackage, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return mockPackage{}, fmt.Errorf("want a package name and a comma-separated list of interfaces")
+	}
+	m := mockPackage{name: fields[0], interfaces: splitList(fields[1])}
+	if !isIdentifier(m.name) {
+		return mockPackage{}, fmt.Errorf("%q is not a valid package name", m.name)
+	}
+	if len(m.interfaces) == 0 {
+		return mockPackage{}, fmt.Errorf("no interfaces")
+	}
+	for _, iface := range m.interfaces {
+		if !isIdentifier(iface) {
+			return mockPackage{}, fmt.Errorf("%q is not a valid interface name", iface)
+		}
+	}
+	return m, nil
+}
+
+// isIdentifier returns whether "s" is a Go identifier that is not a
+// keyword.
+func isIdentifier(s string) bool {
+	if s == "" || token.Lookup(s).IsKeyword() {
+		return false
+	}
+	for i, c := range s {
+		if !unicode.IsLetter(c) && c != '_' && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// mockIndex finds mock packages declared in any directory in the
+// repository, so that imports of mock packages can be resolved before the
+// directories that declare them are visited.
+type mockIndex struct {
+	c     *config.Config
+	cache map[string][]mockPackage
+}
+
+func newMockIndex(c *config.Config) *mockIndex {
+	return &mockIndex{c: c, cache: make(map[string][]mockPackage)}
+}
+
+// lookup returns the label of the mock package with the import path
+// "importpath", imported from the directory "dir". Mock packages are
+// declared in the package whose import path is the parent of importpath.
+// packageDir finds the directory of that package, so import comments and
+// vendored packages are handled as they are for other imports.
+func (x *mockIndex) lookup(importpath, dir string, packageDir func(importpath, dir string) (string, bool)) (label, bool) {
+	parent, name := path.Dir(importpath), path.Base(importpath)
+	if parent == "." || !isIdentifier(name) {
+		return label{}, false
+	}
+	pkg, ok := packageDir(parent, dir)
+	if !ok {
+		return label{}, false
+	}
+	for _, m := range x.get(pkg) {
+		if m.name == name {
+			if pkg == dir {
+				return label{name: name, relative: true}, true
+			}
+			return label{pkg: pkg, name: name}, true
+		}
+	}
+	return label{}, false
+}
+
+// get returns the mock packages declared in the directory "rel".
+func (x *mockIndex) get(rel string) []mockPackage {
+	if ms, ok := x.cache[rel]; ok {
+		return ms
+	}
+	var ms []mockPackage
+	if f := packages.ReadBuildFile(x.c, filepath.Join(x.c.RepoRoot, filepath.FromSlash(rel))); f != nil {
+		for _, d := range config.ParseDirectives(f) {
+			if d.Key != "mock" {
+				continue
+			}
+			if m, err := parseMockDirective(d.Value); err == nil {
+				ms = append(ms, m)
+			}
+		}
+	}
+	x.cache[rel] = ms
+	return ms
+}
+
+// generateMocks generates rules for the mock packages declared in the
+// directory of "pkg". mockgen is run in reflect mode: a program written by
+// mockgen is built with the library, then run to generate the mocks.
+// It returns the rules and a parallel list of their imports.
+func (g *generator) generateMocks(pkg *packages.Package, library string) ([]*bzl.Rule, []interface{}) {
+	if len(g.gc.mocks) == 0 {
+		return nil, nil
+	}
+	if library == "" || pkg.IsCommand() {
+		log.Printf("%s: gazelle:mock requires a library that can be imported", pkg.Rel)
+		return nil, nil
+	}
+
+	var rules []*bzl.Rule
+	var imports []interface{}
+	add := func(r *bzl.Rule, imps ...string) {
+		rules = append(rules, r)
+		if len(imps) == 0 {
+			imports = append(imports, nil)
+		} else {
+			imports = append(imports, packages.PlatformStrings{Generic: imps})
+		}
+	}
+
+	importpath := pkg.ImportPath(g.c.GoPrefix)
+	for _, m := range g.gc.mocks {
+		ifaces := strings.Join(m.interfaces, ",")
+		prog := m.name + "_mockgen_prog"
+		bin := m.name + "_mockgen_bin"
+		out := m.name + "_mockgen"
+		add(newRule("genrule", nil, []keyvalue{
+			{"name", prog},
+			{"outs", []string{prog + ".go"}},
+			{"cmd", fmt.Sprintf("$(location %s) -prog_only %s %s > $@", mockgenLabel, importpath, ifaces)},
+			{"tools", []string{mockgenLabel}},
+		}))
+		add(newRule("go_binary", nil, []keyvalue{
+			{"name", bin},
+			{"srcs", []string{prog + ".go"}},
+			{"visibility", []string{privateVisibility}},
+		}), importpath, "github.com/golang/mock/mockgen/model")
+		add(newRule("genrule", nil, []keyvalue{
+			{"name", out},
+			{"outs", []string{out + ".go"}},
+			{"cmd", fmt.Sprintf("$(location %s) -exec_only $(location :%s) -package %s %s %s > $@", mockgenLabel, bin, m.name, importpath, ifaces)},
+			{"tools", []string{mockgenLabel, ":" + bin}},
+		}))
+		add(newRule("go_library", nil, []keyvalue{
+			{"name", m.name},
+			{"srcs", []string{out + ".go"}},
+			{"importpath", path.Join(importpath, m.name)},
+			{"visibility", g.visibility(pkg.Rel)},
+		}), importpath, "github.com/golang/mock/gomock")
+	}
+	return rules, imports
+}
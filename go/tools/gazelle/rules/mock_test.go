@@ -0,0 +1,139 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+func TestParseMockDirective(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    mockPackage
+		wantErr bool
+	}{
+		{value: "mocks Reader", want: mockPackage{name: "mocks", interfaces: []string{"Reader"}}},
+		{value: "mock_io Reader,Writer", want: mockPackage{name: "mock_io", interfaces: []string{"Reader", "Writer"}}},
+		{value: "mocks", wantErr: true},
+		{value: "mocks Reader Writer", wantErr: true},
+		{value: "mock-io Reader", wantErr: true},
+		{value: "func Reader", wantErr: true},
+		{value: "mocks 1Reader", wantErr: true},
+		{value: "mocks ,", wantErr: true},
+	} {
+		got, err := parseMockDirective(tc.value)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseMockDirective(%q) succeeded; want error", tc.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMockDirective(%q): %v", tc.value, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseMockDirective(%q) = %#v; want %#v", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestMockLookup(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "mock_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, rel := range []string{
+		"a",
+		"commented",
+		"vendor/example.com/dep",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(p, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(p, "BUILD"), []byte("# gazelle:mock mocks Reader\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(p, "lib.go"), []byte("package lib\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		desc, prefix, importpath, from string
+		want                           label
+	}{
+		{
+			desc:       "prefix",
+			prefix:     "example.com/repo",
+			importpath: "example.com/repo/a/mocks",
+			from:       "cmd",
+			want:       label{pkg: "a", name: "mocks"},
+		}, {
+			desc:       "same directory",
+			prefix:     "example.com/repo",
+			importpath: "example.com/repo/a/mocks",
+			from:       "a",
+			want:       label{name: "mocks", relative: true},
+		}, {
+			desc:       "not a mock",
+			prefix:     "example.com/repo",
+			importpath: "example.com/repo/a/other",
+			from:       "cmd",
+			want:       label{pkg: "a/other", name: defaultLibName},
+		}, {
+			desc:       "import comment",
+			prefix:     "example.com/repo",
+			importpath: "example.com/other/commented/mocks",
+			from:       "cmd",
+			want:       label{pkg: "commented", name: "mocks"},
+		}, {
+			desc:       "import comment without prefix",
+			importpath: "example.com/other/commented/mocks",
+			from:       "cmd",
+			want:       label{pkg: "commented", name: "mocks"},
+		}, {
+			desc:       "vendored",
+			prefix:     "example.com/repo",
+			importpath: "example.com/dep/mocks",
+			from:       "cmd",
+			want:       label{pkg: "vendor/example.com/dep", name: "mocks"},
+		},
+	} {
+		c := &config.Config{
+			RepoRoot:            dir,
+			GoPrefix:            tc.prefix,
+			DepMode:             config.VendorMode,
+			ValidBuildFileNames: config.DefaultValidBuildFileNames,
+			ImportComments:      map[string]string{"example.com/other/commented": "commented"},
+		}
+		g := newGenerator(c)
+		got, err := g.r.resolve(tc.importpath, tc.from)
+		if err != nil {
+			t.Errorf("%s: resolve(%q) failed with %v; want success", tc.desc, tc.importpath, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: resolve(%q) = %s; want %s", tc.desc, tc.importpath, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+)
+
+// dirnamePlaceholder is replaced with the base name of a package's directory
+// in naming templates.
+const dirnamePlaceholder = "{dirname}"
+
+// naming is a set of templates for the names of generated targets. Each
+// template may contain dirnamePlaceholder.
+type naming struct {
+	library, cgoLibrary, binary, test, xtest string
+}
+
+var (
+	// defaultNaming gives every library the same name, so packages are
+	// referenced with labels like //foo/bar:go_default_library.
+	defaultNaming = naming{
+		library:    defaultLibName,
+		cgoLibrary: defaultCgoLibName,
+		binary:     dirnamePlaceholder,
+		test:       defaultTestName,
+		xtest:      defaultXTestName,
+	}
+
+	// importNaming names each library after the last element of its import
+	// path, so packages are referenced with labels like //foo/bar.
+	importNaming = naming{
+		library:    dirnamePlaceholder,
+		cgoLibrary: dirnamePlaceholder + "_cgo",
+		binary:     dirnamePlaceholder,
+		test:       dirnamePlaceholder + "_test",
+		xtest:      dirnamePlaceholder + "_xtest",
+	}
+
+	namingConventions = map[string]naming{
+		"default": defaultNaming,
+		"import":  importNaming,
+	}
+)
+
+// names holds the names of the targets generated in a package.
+type names struct {
+	library, cgoLibrary, binary, test, xtest string
+}
+
+// expand returns the names of targets for the package in a directory with
+// the base name "dirname".
+func (n naming) expand(dirname string) names {
+	x := func(t string) string {
+		return strings.Replace(t, dirnamePlaceholder, dirname, -1)
+	}
+	return names{
+		library:    x(n.library),
+		cgoLibrary: x(n.cgoLibrary),
+		binary:     x(n.binary),
+		test:       x(n.test),
+		xtest:      x(n.xtest),
+	}
+}
+
+// applyDirective updates the naming templates with a directive. It returns
+// false if the directive is not related to naming. Invalid directives are
+// logged and ignored.
+func (n *naming) applyDirective(rel string, d config.Directive) bool {
+	var field *string
+	switch d.Key {
+	case "naming_convention":
+		nc, ok := namingConventions[d.Value]
+		if !ok {
+			log.Printf("%s: unknown naming convention %q", rel, d.Value)
+		} else {
+			*n = nc
+		}
+		return true
+	case "library_name":
+		field = &n.library
+	case "cgo_library_name":
+		field = &n.cgoLibrary
+	case "binary_name":
+		field = &n.binary
+	case "test_name":
+		field = &n.test
+	case "xtest_name":
+		field = &n.xtest
+	default:
+		return false
+	}
+	if err := checkNameTemplate(d.Value); err != nil {
+		log.Printf("%s: gazelle:%s: %v", rel, d.Key, err)
+		return true
+	}
+	*field = d.Value
+	return true
+}
+
+func checkNameTemplate(t string) error {
+	if t == "" {
+		return fmt.Errorf("empty name template")
+	}
+	name := strings.Replace(t, dirnamePlaceholder, "x", -1)
+	if strings.ContainsAny(name, "{}:/ \t") {
+		return fmt.Errorf("invalid name template %q", t)
+	}
+	return nil
+}
+
+// dirName returns the base name of the directory "rel". The repository
+// root is named after its directory.
+func dirName(c *config.Config, rel string) string {
+	if rel == "" {
+		return filepath.Base(c.RepoRoot)
+	}
+	return path.Base(rel)
+}
+
+// namingIndex finds the naming templates for any directory in the
+// repository, so that imports can be resolved to the names of libraries in
+// directories that have not been configured yet. It reads naming
+// directives from build files in the directory and its parents.
+type namingIndex struct {
+	c     *config.Config
+	cache map[string]naming
+}
+
+func newNamingIndex(c *config.Config) *namingIndex {
+	return &namingIndex{c: c, cache: make(map[string]naming)}
+}
+
+// libraryName returns the name of the go_library in the directory "rel".
+func (x *namingIndex) libraryName(rel string) string {
+	return x.get(rel).expand(dirName(x.c, rel)).library
+}
+
+func (x *namingIndex) get(rel string) naming {
+	if n, ok := x.cache[rel]; ok {
+		return n
+	}
+	n := defaultNaming
+	if rel != "" {
+		parent := path.Dir(rel)
+		if parent == "." {
+			parent = ""
+		}
+		n = x.get(parent)
+	}
+	if f := packages.ReadBuildFile(x.c, filepath.Join(x.c.RepoRoot, filepath.FromSlash(rel))); f != nil {
+		for _, d := range config.ParseDirectives(f) {
+			n.applyDirective(rel, d)
+		}
+	}
+	x.cache[rel] = n
+	return n
+}
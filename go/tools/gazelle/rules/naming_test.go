@@ -0,0 +1,123 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+func TestNamingExpand(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		n    naming
+		want names
+	}{
+		{
+			desc: "default",
+			n:    defaultNaming,
+			want: names{
+				library:    "go_default_library",
+				cgoLibrary: "cgo_default_library",
+				binary:     "foo",
+				test:       "go_default_test",
+				xtest:      "go_default_xtest",
+			},
+		}, {
+			desc: "import",
+			n:    importNaming,
+			want: names{
+				library:    "foo",
+				cgoLibrary: "foo_cgo",
+				binary:     "foo",
+				test:       "foo_test",
+				xtest:      "foo_xtest",
+			},
+		},
+	} {
+		if got := tc.n.expand("foo"); got != tc.want {
+			t.Errorf("%s: got %#v; want %#v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestNamingDirectives(t *testing.T) {
+	n := defaultNaming
+	for _, d := range []config.Directive{
+		{Key: "naming_convention", Value: "import"},
+		{Key: "test_name", Value: "{dirname}_unit_test"},
+		{Key: "library_name", Value: "bad:name"},
+		{Key: "binary_name", Value: "{dirname}_bin"},
+		{Key: "naming_convention", Value: "unknown"},
+	} {
+		if !n.applyDirective("", d) {
+			t.Errorf("directive %q was not recognized", d.Key)
+		}
+	}
+	if n.applyDirective("", config.Directive{Key: "default_visibility"}) {
+		t.Errorf("default_visibility was recognized as a naming directive")
+	}
+	want := names{
+		library:    "foo",
+		cgoLibrary: "foo_cgo",
+		binary:     "foo_bin",
+		test:       "foo_unit_test",
+		xtest:      "foo_xtest",
+	}
+	if got := n.expand("foo"); got != want {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+func TestNamingIndex(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "naming_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []struct{ path, content string }{
+		{"BUILD", "# gazelle:naming_convention import\n"},
+		{"a/b/BUILD.bazel", "# gazelle:library_name go_default_library\n"},
+		{"a/b/c/x.go", "package c\n"},
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(f.content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &config.Config{RepoRoot: dir, ValidBuildFileNames: config.DefaultValidBuildFileNames}
+	x := newNamingIndex(c)
+	for _, tc := range []struct {
+		rel, want string
+	}{
+		{"a", "a"},
+		{"a/b", "go_default_library"},
+		{"a/b/c", "go_default_library"},
+		{"d", "d"},
+	} {
+		if got := x.libraryName(tc.rel); got != tc.want {
+			t.Errorf("libraryName(%q) = %q; want %q", tc.rel, got, tc.want)
+		}
+	}
+}
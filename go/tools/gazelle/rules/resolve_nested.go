@@ -0,0 +1,69 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+// nestedResolver resolves imports of packages in repositories nested inside
+// the current repository. These repositories are not walked, so their
+// packages are referenced as external repositories.
+type nestedResolver struct {
+	repos []config.NestedRepo
+}
+
+// match returns the nested repository with the longest prefix that
+// contains "importpath". false is returned if there is no such repository.
+func (n nestedResolver) match(importpath string) (config.NestedRepo, bool) {
+	var best config.NestedRepo
+	found := false
+	for _, r := range n.repos {
+		if importpath != r.GoPrefix && !strings.HasPrefix(importpath, r.GoPrefix+"/") {
+			continue
+		}
+		if !found || len(r.GoPrefix) > len(best.GoPrefix) {
+			best = r
+			found = true
+		}
+	}
+	return best, found
+}
+
+// resolve resolves "importpath" into a label in the nested repository that
+// contains it.
+func (n nestedResolver) resolve(importpath, dir string) (label, error) {
+	r, ok := n.match(importpath)
+	if !ok {
+		return label{}, fmt.Errorf("import path %q is not in a nested repository", importpath)
+	}
+	name := r.Name
+	if name == "" {
+		name = ImportPathToBazelRepoName(r.GoPrefix)
+	}
+	var pkg string
+	if importpath != r.GoPrefix {
+		pkg = strings.TrimPrefix(importpath, r.GoPrefix+"/")
+	}
+	return label{
+		repo: name,
+		pkg:  pkg,
+		name: defaultLibName,
+	}, nil
+}
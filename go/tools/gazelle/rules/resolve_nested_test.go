@@ -0,0 +1,71 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+func TestNestedResolver(t *testing.T) {
+	r := nestedResolver{repos: []config.NestedRepo{
+		{Rel: "third_party/foo", GoPrefix: "example.com/repo/third_party/foo"},
+		{Rel: "third_party/foo/bar", Name: "bar", GoPrefix: "example.com/repo/third_party/foo/bar"},
+		{Rel: "mod", GoPrefix: "example.com/mod"},
+	}}
+	for _, spec := range []struct {
+		importpath string
+		want       label
+	}{
+		{
+			importpath: "example.com/repo/third_party/foo",
+			want:       label{repo: "com_example_repo_third_party_foo", name: defaultLibName},
+		},
+		{
+			importpath: "example.com/repo/third_party/foo/lib",
+			want:       label{repo: "com_example_repo_third_party_foo", pkg: "lib", name: defaultLibName},
+		},
+		{
+			importpath: "example.com/repo/third_party/foo/bar/baz",
+			want:       label{repo: "bar", pkg: "baz", name: defaultLibName},
+		},
+		{
+			importpath: "example.com/mod/x",
+			want:       label{repo: "com_example_mod", pkg: "x", name: defaultLibName},
+		},
+	} {
+		l, err := r.resolve(spec.importpath, "some/package")
+		if err != nil {
+			t.Errorf("r.resolve(%q) failed with %v; want success", spec.importpath, err)
+			continue
+		}
+		if got, want := l, spec.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("r.resolve(%q) = %s; want %s", spec.importpath, got, want)
+		}
+	}
+
+	for _, importpath := range []string{
+		"example.com/repo/third_party",
+		"example.com/repo/third_party/foobar",
+		"example.com/module",
+	} {
+		if l, err := r.resolve(importpath, ""); err == nil {
+			t.Errorf("r.resolve(%q) = %s; want error", importpath, l)
+		}
+	}
+}
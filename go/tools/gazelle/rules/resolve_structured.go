@@ -25,6 +25,10 @@ import (
 // the one of goPrefix.
 type structuredResolver struct {
 	goPrefix string
+
+	// libName returns the name of the library in a directory, given its
+	// slash-separated path relative to the repository root.
+	libName func(rel string) string
 }
 
 // resolve takes a Go importpath within the same respository as r.goPrefix
@@ -35,15 +39,15 @@ func (r structuredResolver) resolve(importpath, dir string) (label, error) {
 	}
 
 	if importpath == r.goPrefix {
-		return label{name: defaultLibName}, nil
+		return label{name: r.libName("")}, nil
 	}
 
 	if prefix := r.goPrefix + "/"; strings.HasPrefix(importpath, prefix) {
 		pkg := strings.TrimPrefix(importpath, prefix)
 		if pkg == dir {
-			return label{name: defaultLibName, relative: true}, nil
+			return label{name: r.libName(pkg), relative: true}, nil
 		}
-		return label{pkg: pkg, name: defaultLibName}, nil
+		return label{pkg: pkg, name: r.libName(pkg)}, nil
 	}
 
 	return label{}, fmt.Errorf("importpath %q does not start with goPrefix %q", importpath, r.goPrefix)
@@ -21,7 +21,7 @@ import (
 )
 
 func TestStructuredResolver(t *testing.T) {
-	r := structuredResolver{goPrefix: "example.com/repo"}
+	r := structuredResolver{goPrefix: "example.com/repo", libName: defaultLib}
 	for _, spec := range []struct {
 		importpath string
 		curPkg     string
@@ -77,7 +77,7 @@ func TestStructuredResolver(t *testing.T) {
 }
 
 func TestStructuredResolverError(t *testing.T) {
-	r := structuredResolver{goPrefix: "example.com/repo"}
+	r := structuredResolver{goPrefix: "example.com/repo", libName: defaultLib}
 
 	for _, importpath := range []string{
 		"example.com/another",
@@ -89,3 +89,9 @@ func TestStructuredResolverError(t *testing.T) {
 		}
 	}
 }
+
+// defaultLib returns the name of the library in every directory with the
+// default naming convention.
+func defaultLib(rel string) string {
+	return defaultLibName
+}
@@ -1,11 +1,104 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
 package rules
 
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
 // vendoredResolver resolves external packages as packages in vendor/.
-type vendoredResolver struct{}
+// Like the go command, it searches for a vendor directory containing the
+// package, starting in the importing package's directory and moving up
+// toward the repository root. Packages that are not vendored anywhere are
+// resolved with fallback.
+type vendoredResolver struct {
+	// repoRoot is the absolute path to the repository root directory.
+	repoRoot string
+
+	// fallback resolves imports that are not found in any vendor directory.
+	fallback labelResolver
+
+	// libName returns the name of the library in a directory, given its
+	// slash-separated path relative to the repository root.
+	libName func(rel string) string
+
+	// pkgs caches whether slash-separated paths relative to repoRoot are
+	// directories containing Go files.
+	pkgs map[string]bool
+}
+
+func newVendoredResolver(repoRoot string, fallback labelResolver, libName func(string) string) *vendoredResolver {
+	return &vendoredResolver{
+		repoRoot: repoRoot,
+		fallback: fallback,
+		libName:  libName,
+		pkgs:     make(map[string]bool),
+	}
+}
+
+func (v *vendoredResolver) resolve(importpath, dir string) (label, error) {
+	if l, ok := v.lookup(importpath, dir); ok {
+		return l, nil
+	}
+	return v.fallback.resolve(importpath, dir)
+}
+
+// lookup returns a label for "importpath" in the nearest vendor directory
+// that contains it, searching from "dir" up to the repository root. false
+// is returned if no vendor directory contains the package.
+func (v *vendoredResolver) lookup(importpath, dir string) (label, bool) {
+	from := dir
+	for {
+		pkg := path.Join(dir, "vendor", importpath)
+		if v.isPackage(pkg) {
+			if pkg == from {
+				return label{name: v.libName(pkg), relative: true}, true
+			}
+			return label{pkg: pkg, name: v.libName(pkg)}, true
+		}
+		if dir == "" {
+			return label{}, false
+		}
+		dir = path.Dir(dir)
+		if dir == "." {
+			dir = ""
+		}
+	}
+}
 
-func (v vendoredResolver) resolve(importpath, dir string) (label, error) {
-	return label{
-		pkg:  "vendor/" + importpath,
-		name: defaultLibName,
-	}, nil
+// isPackage returns whether "rel", a slash-separated path relative to the
+// repository root, is a directory containing Go files. Like the go command,
+// lookup skips vendor directories without Go files, such as directories
+// that only contain data.
+func (v *vendoredResolver) isPackage(rel string) bool {
+	if isPkg, ok := v.pkgs[rel]; ok {
+		return isPkg
+	}
+	isPkg := false
+	if files, err := ioutil.ReadDir(filepath.Join(v.repoRoot, filepath.FromSlash(rel))); err == nil {
+		for _, fi := range files {
+			if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".go") {
+				isPkg = true
+				break
+			}
+		}
+	}
+	v.pkgs[rel] = isPkg
+	return isPkg
 }
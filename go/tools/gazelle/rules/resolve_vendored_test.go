@@ -0,0 +1,248 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+// createPackages creates a directory with a Go file for each of the
+// slash-separated paths "rels" in "dir".
+func createPackages(t *testing.T, dir string, rels ...string) {
+	for _, rel := range rels {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(p, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(p, "lib.go"), []byte("package lib\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVendoredResolver(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "vendored_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	createPackages(t, dir,
+		"vendor/example.com/a",
+		"vendor/example.com/b",
+		"cmd/vendor/example.com/a",
+		"cmd/tool/lib")
+	// Directories without Go files are not packages, so they don't shadow
+	// packages in other vendor directories or external repositories.
+	for _, p := range []string{
+		"cmd/vendor/example.com/b",
+		"vendor/example.com/data/testdata",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.FromSlash(p)), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "vendor/example.com/data/testdata/data.txt"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	fallback := resolverFunc(func(importpath, dir string) (label, error) {
+		return label{repo: "external", pkg: importpath, name: defaultLibName}, nil
+	})
+	v := newVendoredResolver(dir, fallback, defaultLib)
+	for _, spec := range []struct {
+		importpath, dir string
+		want            label
+	}{
+		{
+			importpath: "example.com/a",
+			dir:        "lib",
+			want:       label{pkg: "vendor/example.com/a", name: defaultLibName},
+		},
+		{
+			importpath: "example.com/a",
+			dir:        "",
+			want:       label{pkg: "vendor/example.com/a", name: defaultLibName},
+		},
+		{
+			importpath: "example.com/a",
+			dir:        "cmd/tool/lib",
+			want:       label{pkg: "cmd/vendor/example.com/a", name: defaultLibName},
+		},
+		{
+			importpath: "example.com/b",
+			dir:        "cmd/tool/lib",
+			want:       label{pkg: "vendor/example.com/b", name: defaultLibName},
+		},
+		{
+			importpath: "example.com/c",
+			dir:        "cmd/tool/lib",
+			want:       label{repo: "external", pkg: "example.com/c", name: defaultLibName},
+		},
+		{
+			importpath: "example.com/data/testdata",
+			dir:        "lib",
+			want:       label{repo: "external", pkg: "example.com/data/testdata", name: defaultLibName},
+		},
+	} {
+		l, err := v.resolve(spec.importpath, spec.dir)
+		if err != nil {
+			t.Errorf("v.resolve(%q, %q) failed with %v; want success", spec.importpath, spec.dir, err)
+			continue
+		}
+		if got, want := l, spec.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("v.resolve(%q, %q) = %s; want %s", spec.importpath, spec.dir, got, want)
+		}
+	}
+}
+
+func TestVendoredShadowsRepo(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "vendored_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	createPackages(t, dir, "vendor/example.com/repo/lib")
+
+	c := &config.Config{
+		RepoRoot: dir,
+		GoPrefix: "example.com/repo",
+		DepMode:  config.VendorMode,
+	}
+	g := newGenerator(c)
+	for _, spec := range []struct {
+		importpath string
+		want       label
+	}{
+		{
+			importpath: "example.com/repo/lib",
+			want:       label{pkg: "vendor/example.com/repo/lib", name: defaultLibName},
+		},
+		{
+			importpath: "example.com/repo/other",
+			want:       label{pkg: "other", name: defaultLibName},
+		},
+	} {
+		l, err := g.r.resolve(spec.importpath, "cmd")
+		if err != nil {
+			t.Errorf("resolve(%q) failed with %v; want success", spec.importpath, err)
+			continue
+		}
+		if got, want := l, spec.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("resolve(%q) = %s; want %s", spec.importpath, got, want)
+		}
+	}
+}
+
+func TestHybridModeOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "vendored_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	createPackages(t, dir,
+		"vendor/github.com/foo/vendored",
+		"vendor/github.com/foo/overridden")
+
+	c := &config.Config{
+		RepoRoot: dir,
+		GoPrefix: "example.com/repo",
+		DepMode:  config.HybridMode,
+		DepModeOverrides: map[string]config.DependencyMode{
+			"github.com/foo/overridden": config.ExternalMode,
+		},
+	}
+	g := newGenerator(c)
+	for _, spec := range []struct {
+		importpath string
+		want       label
+	}{
+		{
+			importpath: "github.com/foo/vendored",
+			want:       label{pkg: "vendor/github.com/foo/vendored", name: defaultLibName},
+		},
+		{
+			importpath: "github.com/foo/external",
+			want:       label{repo: "com_github_foo_external", name: defaultLibName},
+		},
+		{
+			importpath: "github.com/foo/overridden/sub",
+			want:       label{repo: "com_github_foo_overridden", pkg: "sub", name: defaultLibName},
+		},
+	} {
+		l, err := g.r.resolve(spec.importpath, "lib")
+		if err != nil {
+			t.Errorf("resolve(%q) failed with %v; want success", spec.importpath, err)
+			continue
+		}
+		if got, want := l, spec.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("resolve(%q) = %s; want %s", spec.importpath, got, want)
+		}
+	}
+}
+
+func TestVendoredAndHybridModes(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "vendored_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	createPackages(t, dir, "vendor/github.com/foo/vendored")
+
+	for _, tc := range []struct {
+		mode               config.DependencyMode
+		vendored, external label
+	}{
+		{
+			mode:     config.VendorMode,
+			vendored: label{pkg: "vendor/github.com/foo/vendored", name: defaultLibName},
+			external: label{pkg: "vendor/github.com/foo/external", name: defaultLibName},
+		},
+		{
+			mode:     config.HybridMode,
+			vendored: label{pkg: "vendor/github.com/foo/vendored", name: defaultLibName},
+			external: label{repo: "com_github_foo_external", name: defaultLibName},
+		},
+	} {
+		c := &config.Config{
+			RepoRoot: dir,
+			GoPrefix: "example.com/repo",
+			DepMode:  tc.mode,
+		}
+		g := newGenerator(c)
+		for _, spec := range []struct {
+			importpath string
+			want       label
+		}{
+			{"github.com/foo/vendored", tc.vendored},
+			{"github.com/foo/external", tc.external},
+		} {
+			l, err := g.r.resolve(spec.importpath, "lib")
+			if err != nil {
+				t.Errorf("mode %v: resolve(%q) failed with %v; want success", tc.mode, spec.importpath, err)
+				continue
+			}
+			if got, want := l, spec.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("mode %v: resolve(%q) = %s; want %s", tc.mode, spec.importpath, got, want)
+			}
+		}
+	}
+}
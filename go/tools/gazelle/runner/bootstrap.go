@@ -0,0 +1,324 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/fileutil"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/merger"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+)
+
+// InferGoPrefix guesses the Go import path prefix for the repository
+// rooted at c.RepoRoot. The following sources are tried in order:
+//
+//   - import comments in package clauses, e.g., package foo // import "x/foo"
+//   - the location of c.RepoRoot inside a GOPATH src directory
+//   - the URL of the git remote "origin" (or the first remote), e.g.,
+//     https://github.com/org/repo.git is mapped to github.com/org/repo
+//
+// The prefix is returned with a short description of where it came from.
+// An error is returned if no prefix can be inferred.
+func InferGoPrefix(c *config.Config) (prefix, source string, err error) {
+	if prefix, ok := prefixFromImportComments(c); ok {
+		return prefix, "import comments", nil
+	}
+	if prefix, ok := prefixFromGopath(c.RepoRoot); ok {
+		return prefix, "GOPATH", nil
+	}
+	if prefix, ok := prefixFromGitRemote(c.RepoRoot); ok {
+		return prefix, "git remote", nil
+	}
+	return "", "", errors.New("could not infer the prefix from import comments, GOPATH, or a git remote")
+}
+
+// prefixFromImportComments finds import comments in the repository with
+// packages.FindImportComments. The prefix is the import path in a comment
+// with the location of its package trimmed from the end. If several
+// comments imply a prefix, the one closest to the repository root is used.
+func prefixFromImportComments(c *config.Config) (string, bool) {
+	pc := *c
+	pc.GoPrefix = ""
+	var prefix, prefixRel string
+	for importPath, rel := range packages.FindImportComments(&pc) {
+		var p string
+		if rel == "" {
+			p = importPath
+		} else if strings.HasSuffix(importPath, "/"+rel) {
+			p = strings.TrimSuffix(importPath, "/"+rel)
+		} else {
+			continue
+		}
+		if prefix == "" || len(rel) < len(prefixRel) || len(rel) == len(prefixRel) && rel < prefixRel {
+			prefix, prefixRel = p, rel
+		}
+	}
+	return prefix, prefix != ""
+}
+
+// prefixFromGopath returns the path of "repoRoot" relative to a src
+// directory in GOPATH.
+func prefixFromGopath(repoRoot string) (string, bool) {
+	for _, p := range filepath.SplitList(build.Default.GOPATH) {
+		src := filepath.Join(p, "src")
+		if repoRoot != src && isDescendingDir(repoRoot, src) {
+			rel, err := filepath.Rel(src, repoRoot)
+			if err != nil {
+				continue
+			}
+			return filepath.ToSlash(rel), true
+		}
+	}
+	return "", false
+}
+
+// prefixFromGitRemote finds the git repository containing "repoRoot" and
+// derives a prefix from the URL of its remote "origin" (or the first
+// remote, if there is no origin) and the location of repoRoot in the git
+// repository.
+func prefixFromGitRemote(repoRoot string) (string, bool) {
+	gitRoot, gitDir, ok := findGitDir(repoRoot)
+	if !ok {
+		return "", false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return "", false
+	}
+	remotes := parseGitRemotes(string(data))
+	url, ok := remotes["origin"]
+	if !ok {
+		var names []string
+		for name := range remotes {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			return "", false
+		}
+		sort.Strings(names)
+		url = remotes[names[0]]
+	}
+	prefix, ok := importPathFromURL(url)
+	if !ok {
+		return "", false
+	}
+	rel, err := filepath.Rel(gitRoot, repoRoot)
+	if err != nil {
+		return "", false
+	}
+	return path.Join(prefix, filepath.ToSlash(rel)), true
+}
+
+// findGitDir searches "dir" and its parents for a .git directory. It
+// returns the directory that contains .git and the directory where git
+// stores the repository's configuration. .git may also be a file that
+// points to the git directory, as in worktrees and submodules.
+func findGitDir(dir string) (root, gitDir string, ok bool) {
+	for {
+		p := filepath.Join(dir, ".git")
+		if fi, err := os.Stat(p); err == nil {
+			if fi.IsDir() {
+				return dir, p, true
+			}
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				return "", "", false
+			}
+			gitDir := strings.TrimSpace(strings.TrimPrefix(string(data), "gitdir:"))
+			if !filepath.IsAbs(gitDir) {
+				gitDir = filepath.Join(dir, gitDir)
+			}
+			// Worktrees keep their configuration in a common directory.
+			if common, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+				commonDir := strings.TrimSpace(string(common))
+				if !filepath.IsAbs(commonDir) {
+					commonDir = filepath.Join(gitDir, commonDir)
+				}
+				gitDir = commonDir
+			}
+			return dir, gitDir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", false
+		}
+		dir = parent
+	}
+}
+
+// parseGitRemotes returns the URLs of remotes in a git config file, keyed
+// by remote name.
+func parseGitRemotes(config string) map[string]string {
+	remotes := make(map[string]string)
+	remote := ""
+	for _, line := range strings.Split(config, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			remote = ""
+			if strings.HasPrefix(line, `[remote "`) && strings.HasSuffix(line, `"]`) {
+				remote = line[len(`[remote "`) : len(line)-len(`"]`)]
+			}
+			continue
+		}
+		if remote == "" {
+			continue
+		}
+		if i := strings.Index(line, "="); i >= 0 && strings.TrimSpace(line[:i]) == "url" {
+			remotes[remote] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return remotes
+}
+
+// importPathFromURL converts a git remote URL to an import path. Both
+// URLs with schemes (https://github.com/org/repo.git) and scp-like
+// addresses (git@github.com:org/repo.git) are supported.
+func importPathFromURL(url string) (string, bool) {
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+	var host, p string
+	if i := strings.Index(url, "://"); i >= 0 {
+		rest := url[i+len("://"):]
+		j := strings.Index(rest, "/")
+		if j < 0 {
+			return "", false
+		}
+		host, p = rest[:j], rest[j+1:]
+		if k := strings.LastIndex(host, "@"); k >= 0 {
+			host = host[k+1:]
+		}
+		if k := strings.Index(host, ":"); k >= 0 {
+			host = host[:k]
+		}
+	} else if i := strings.Index(url, ":"); i >= 0 && !strings.Contains(url[:i], "/") {
+		host, p = url[:i], url[i+1:]
+		if k := strings.LastIndex(host, "@"); k >= 0 {
+			host = host[k+1:]
+		}
+	} else {
+		return "", false
+	}
+	p = strings.Trim(p, "/")
+	if host == "" || p == "" {
+		return "", false
+	}
+	return host + "/" + p, true
+}
+
+// RulesGoVersion is the release of rules_go Gazelle was built from. It is
+// set at link time from RULES_GO_VERSION in go/private/version.bzl, and it
+// is empty if Gazelle was built some other way, for example with go get.
+var RulesGoVersion string
+
+// workspaceTemplate is the content of WORKSPACE files created by
+// CreateWorkspace. The argument is the rules_go release.
+const workspaceTemplate = `git_repository(
+    name = "io_bazel_rules_go",
+    remote = "https://github.com/bazelbuild/rules_go.git",
+    tag = %q,
+)
+
+load("@io_bazel_rules_go//go:def.bzl", "go_repositories")
+
+go_repositories()
+`
+
+// CreateWorkspace creates a WORKSPACE file in "dir" that loads the Go
+// rules, pinned to RulesGoVersion. An error is returned if the file already
+// exists, or if RulesGoVersion is not set.
+func CreateWorkspace(dir string) error {
+	if RulesGoVersion == "" {
+		return errors.New("the rules_go release Gazelle was built from is unknown; create a WORKSPACE file as described in the rules_go README")
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "WORKSPACE"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, workspaceTemplate, RulesGoVersion); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteGoPrefix adds a go_prefix rule with c.GoPrefix to the build file in
+// c.RepoRoot, creating the file if it doesn't exist. LoadGoPrefix will
+// read the prefix on later runs. go_prefix is added to an existing load of
+// the Go rules if there is one; load statements are fixed as in fix mode.
+func WriteGoPrefix(c *config.Config) error {
+	p, err := FindBuildFile(c, c.RepoRoot)
+	var f *bzl.File
+	if err == os.ErrNotExist {
+		f = &bzl.File{Path: filepath.Join(c.RepoRoot, c.DefaultBuildFileName())}
+	} else if err != nil {
+		return err
+	} else {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if f, err = bzl.Parse(p, data); err != nil {
+			return err
+		}
+	}
+
+	rule := &bzl.CallExpr{
+		X:    &bzl.LiteralExpr{Token: "go_prefix"},
+		List: []bzl.Expr{&bzl.StringExpr{Value: c.GoPrefix}},
+	}
+
+	// Insert the rule after leading comments and loads.
+	i := 0
+	for ; i < len(f.Stmt); i++ {
+		if _, ok := f.Stmt[i].(*bzl.CommentBlock); ok {
+			continue
+		}
+		if call, ok := f.Stmt[i].(*bzl.CallExpr); ok && isLoadCall(call) {
+			continue
+		}
+		break
+	}
+	stmts := append([]bzl.Expr{}, f.Stmt[:i]...)
+	stmts = append(stmts, rule)
+	f.Stmt = append(stmts, f.Stmt[i:]...)
+	load := &bzl.CallExpr{
+		X: &bzl.LiteralExpr{Token: "load"},
+		List: []bzl.Expr{
+			&bzl.StringExpr{Value: rules.GoRulesBzl},
+			&bzl.StringExpr{Value: "go_prefix"},
+		},
+	}
+	merger.FixLoads(f, []*bzl.CallExpr{load}, nil)
+
+	_, err = fileutil.WriteFile(f.Path, bzl.Format(f))
+	return err
+}
+
+func isLoadCall(call *bzl.CallExpr) bool {
+	x, ok := call.X.(*bzl.LiteralExpr)
+	return ok && x.Token == "load"
+}
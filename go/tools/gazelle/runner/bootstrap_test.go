@@ -0,0 +1,199 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+)
+
+func TestImportPathFromURL(t *testing.T) {
+	for _, tc := range []struct {
+		url, want string
+	}{
+		{"https://github.com/org/repo.git", "github.com/org/repo"},
+		{"https://github.com/org/repo/", "github.com/org/repo"},
+		{"ssh://git@example.com:2222/org/repo.git", "example.com/org/repo"},
+		{"git@github.com:org/repo.git", "github.com/org/repo"},
+		{"/local/path/repo.git", ""},
+		{"https://example.com", ""},
+	} {
+		got, ok := importPathFromURL(tc.url)
+		if tc.want == "" {
+			if ok {
+				t.Errorf("importPathFromURL(%q) = %q; want failure", tc.url, got)
+			}
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("importPathFromURL(%q) = %q; want %q", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestInferGoPrefix(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(rel, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(gopath string) { build.Default.GOPATH = gopath }(build.Default.GOPATH)
+	build.Default.GOPATH = filepath.Join(dir, "gopath")
+
+	write("git/.git/config", `[core]
+	bare = false
+[remote "upstream"]
+	url = https://example.com/upstream/repo.git
+[remote "origin"]
+	url = git@github.com:org/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+`)
+	write("git/sub/lib.go", "package lib")
+	write("comment/lib/lib.go", "// +build go1.8\n\npackage lib // import \"example.com/comment/lib\"\n")
+	write("comment/other/other.go", "package other /* import \"example.com/wrong/other/x\" */\n")
+	write("comment/ex/ex.go", "package ex // import \"example.com/wrong/ex\"\n")
+	write("gopath/src/example.com/gopath/lib.go", "package lib")
+
+	for _, tc := range []struct {
+		rel, want, source string
+	}{
+		{"git", "github.com/org/repo", "git remote"},
+		{"git/sub", "github.com/org/repo/sub", "git remote"},
+		{"comment", "example.com/comment", "import comments"},
+		{"gopath/src/example.com/gopath", "example.com/gopath", "GOPATH"},
+	} {
+		c := &config.Config{
+			RepoRoot:            filepath.Join(dir, filepath.FromSlash(tc.rel)),
+			ValidBuildFileNames: config.DefaultValidBuildFileNames,
+			Excludes:            []string{"ex"},
+		}
+		prefix, source, err := InferGoPrefix(c)
+		if err != nil {
+			t.Errorf("%s: InferGoPrefix failed with %v; want success", tc.rel, err)
+			continue
+		}
+		if prefix != tc.want || source != tc.source {
+			t.Errorf("%s: got %q from %s; want %q from %s", tc.rel, prefix, source, tc.want, tc.source)
+		}
+	}
+}
+
+func TestWriteGoPrefix(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(v string) { RulesGoVersion = v }(RulesGoVersion)
+	RulesGoVersion = ""
+	if err := CreateWorkspace(dir); err == nil {
+		t.Errorf("CreateWorkspace succeeded without RulesGoVersion; want error")
+	}
+	RulesGoVersion = "1.2.3"
+	if err := CreateWorkspace(dir); err != nil {
+		t.Fatalf("CreateWorkspace failed with %v; want success", err)
+	}
+	if err := CreateWorkspace(dir); err == nil {
+		t.Errorf("CreateWorkspace succeeded with existing WORKSPACE; want error")
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "WORKSPACE")); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(data), `tag = "1.2.3",`) {
+		t.Errorf("got WORKSPACE:\n%s\nwant rules_go pinned to 1.2.3", data)
+	}
+
+	buildPath := filepath.Join(dir, "BUILD")
+	if err := ioutil.WriteFile(buildPath, []byte(`# Copyright notice
+
+load("//:foo.bzl", "foo")
+
+foo(name = "foo")
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := &config.Config{
+		Dirs:     []string{dir},
+		GoPrefix: "example.com/repo",
+	}
+	if err := SetDefaults(c); err != nil {
+		t.Fatalf("SetDefaults failed with %v; want success", err)
+	}
+	if err := WriteGoPrefix(c); err != nil {
+		t.Fatalf("WriteGoPrefix failed with %v; want success", err)
+	}
+
+	got, err := ioutil.ReadFile(buildPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Copyright notice
+
+load("//:foo.bzl", "foo")
+load("@io_bazel_rules_go//go:def.bzl", "go_prefix")
+
+go_prefix("example.com/repo")
+
+foo(name = "foo")
+`
+	if string(got) != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+	if prefix, err := LoadGoPrefix(c); err != nil || prefix != c.GoPrefix {
+		t.Errorf("LoadGoPrefix returned %q, %v; want %q, nil", prefix, err, c.GoPrefix)
+	}
+
+	// go_prefix should be added to an existing load of the Go rules.
+	if err := ioutil.WriteFile(buildPath, []byte(`load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(name = "go_default_library")
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteGoPrefix(c); err != nil {
+		t.Fatalf("WriteGoPrefix failed with %v; want success", err)
+	}
+	if got, err = ioutil.ReadFile(buildPath); err != nil {
+		t.Fatal(err)
+	}
+	want = `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_prefix")
+
+go_prefix("example.com/repo")
+
+go_library(name = "go_default_library")
+`
+	if string(got) != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
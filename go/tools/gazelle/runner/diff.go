@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package runner
 
 import (
 	"io/ioutil"
@@ -24,7 +24,9 @@ import (
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
 )
 
-func diffFile(c *config.Config, file *bzl.File) error {
+// DiffFile prints a unified diff between the existing file at file.Path and
+// the formatted content of file to stdout.
+func DiffFile(c *config.Config, file *bzl.File) error {
 	f, err := ioutil.TempFile("", c.DefaultBuildFileName())
 	if err != nil {
 		return err
@@ -0,0 +1,42 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/fileutil"
+)
+
+// FixFile writes a formatted BUILD file to file.Path, replacing any existing
+// file. Nothing is written if the existing file already has the same
+// content.
+func FixFile(c *config.Config, file *bzl.File) error {
+	_, err := fileutil.WriteFile(file.Path, bzl.Format(file))
+	return err
+}
+
+// FixFileAndReport returns an EmitFunc that works like FixFile and calls
+// "report" with the path of each file that was created or changed.
+func FixFileAndReport(report func(c *config.Config, path string)) EmitFunc {
+	return func(c *config.Config, file *bzl.File) error {
+		ok, err := fileutil.WriteFile(file.Path, bzl.Format(file))
+		if ok {
+			report(c, file.Path)
+		}
+		return err
+	}
+}
@@ -13,25 +13,19 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package runner
 
 import (
-	"flag"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	bzl "github.com/bazelbuild/buildtools/build"
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
 )
 
-func TestMain(m *testing.M) {
-	tmpdir := os.Getenv("TEST_TMPDIR")
-	flag.Set("repo_root", tmpdir)
-	os.Exit(m.Run())
-}
-
 func defaultConfig(dir string) *config.Config {
 	c := &config.Config{
 		Dirs:                []string{dir},
@@ -69,8 +63,8 @@ func TestFixFile(t *testing.T) {
 	}
 
 	c := defaultConfig(dir)
-	if err := fixFile(c, stubFile); err != nil {
-		t.Errorf("fixFile(%#v) failed with %v; want success", stubFile, err)
+	if err := FixFile(c, stubFile); err != nil {
+		t.Errorf("FixFile(%#v) failed with %v; want success", stubFile, err)
 		return
 	}
 
@@ -100,7 +94,7 @@ func TestCreateFile(t *testing.T) {
 
 	// Check that Gazelle creates a new file named "BUILD.bazel".
 	c := defaultConfig(dir)
-	run(c, fixFile)
+	Run(c, FixFile)
 
 	buildFile := filepath.Join(dir, "BUILD.bazel")
 	if _, err = os.Stat(buildFile); err != nil {
@@ -129,7 +123,7 @@ func TestUpdateFile(t *testing.T) {
 
 	// Check that Gazelle updates the BUILD file in place.
 	c := defaultConfig(dir)
-	run(c, fixFile)
+	Run(c, FixFile)
 	if st, err := os.Stat(buildFile); err != nil {
 		t.Errorf("could not stat BUILD: %v", err)
 	} else if st.Size() == 0 {
@@ -140,3 +134,66 @@ func TestUpdateFile(t *testing.T) {
 		t.Errorf("BUILD.bazel should not exist")
 	}
 }
+
+func TestFixFileUnchanged(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "BUILD")
+	f, err := bzl.Parse(path, []byte(`foo_rule(name = "bar")`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, bzl.Format(f), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	var changed []string
+	emit := FixFileAndReport(func(_ *config.Config, path string) {
+		changed = append(changed, path)
+	})
+	c := defaultConfig(dir)
+	if err := emit(c, f); err != nil {
+		t.Fatalf("FixFile failed with %v; want success", err)
+	}
+	if len(changed) != 0 {
+		t.Errorf("got changed files %q; want none", changed)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if !fi.ModTime().Equal(old) {
+		t.Errorf("unchanged file was written")
+	}
+
+	// Change the file. It should be replaced, and its mode should be kept.
+	f.Stmt = append(f.Stmt, &bzl.CallExpr{X: &bzl.LiteralExpr{Token: "baz_rule"}})
+	if err := emit(c, f); err != nil {
+		t.Fatalf("FixFile failed with %v; want success", err)
+	}
+	if len(changed) != 1 || changed[0] != path {
+		t.Errorf("got changed files %q; want %q", changed, path)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v; want %v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+	if got, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if want := bzl.Format(f); string(got) != string(want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(files) != 1 {
+		t.Errorf("got %d files in %s; want only BUILD", len(files), dir)
+	}
+}
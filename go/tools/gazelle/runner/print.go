@@ -13,7 +13,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package runner
 
 import (
 	"os"
@@ -22,7 +22,8 @@ import (
 	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
 )
 
-func printFile(c *config.Config, f *bzl.File) error {
+// PrintFile prints the formatted content of f to stdout.
+func PrintFile(c *config.Config, f *bzl.File) error {
 	_, err := os.Stdout.Write(bzl.Format(f))
 	return err
 }
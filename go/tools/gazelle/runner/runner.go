@@ -0,0 +1,454 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package runner generates and updates BUILD files for a set of directories.
+// The gazelle command is a thin wrapper around this package. Other tools may
+// use it to run Gazelle without executing a separate binary.
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/fileutil"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/graph"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/lang"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/merger"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/wspace"
+)
+
+// EmitFunc is called by Run for each BUILD file that was generated or
+// updated. The file has already been merged with the existing file (if any)
+// and formatted. file.Path is the path where the file should be written.
+type EmitFunc func(c *config.Config, file *bzl.File) error
+
+func init() {
+	lang.Register(rules.NewLanguage())
+}
+
+// Run generates BUILD files for the directories in c.Dirs and their
+// subdirectories (unless c.NonRecursive is set), merges them with existing
+// files, and calls emit for each result. Rules are generated by each
+// language returned by lang.Languages. Errors are logged; processing
+// continues with other directories when possible.
+//
+// c should be prepared with SetDefaults before calling Run.
+func Run(c *config.Config, emit EmitFunc) {
+	run(c, lang.Languages(), emit, nil)
+}
+
+// RunWithDeps is like Run, but it also records dependencies between targets
+// in the repository in "g", which may then be checked with CheckDeps.
+func RunWithDeps(c *config.Config, emit EmitFunc, g *graph.Graph) {
+	run(c, lang.Languages(), emit, g)
+}
+
+func run(c *config.Config, langs []lang.Language, emit EmitFunc, g *graph.Graph) {
+	c.NestedRepos, c.ImportComments = packages.ScanRepo(c)
+	walk := packages.WalkDirs
+	if c.NonRecursive {
+		walk = packages.VisitDir
+	}
+	for _, dir := range c.Dirs {
+		walk(c, dir, configureFunc(langs), func(d *packages.Dir) bool {
+			return processDir(d.Config, langs, emit, d, g)
+		})
+	}
+}
+
+// configureFunc returns a function that configures each language for a
+// directory.
+func configureFunc(langs []lang.Language) packages.ConfigureFunc {
+	return func(c *config.Config, rel string, f *bzl.File) {
+		config.ApplyKindMap(c, f)
+		for _, l := range langs {
+			l.Configure(c, rel, f)
+		}
+	}
+}
+
+// Generate is like Run, but instead of writing files, it returns the
+// generated and merged files. Each file's Path is the path where it would
+// be written. Files with a "# gazelle:ignore" comment are not returned.
+func Generate(c *config.Config) []*bzl.File {
+	var files []*bzl.File
+	Run(c, func(_ *config.Config, f *bzl.File) error {
+		files = append(files, f)
+		return nil
+	})
+	return files
+}
+
+// CheckDeps reports problems in the dependency graph "g" recorded by
+// RunWithDeps: cycles, which Bazel would reject, and dependencies forbidden
+// by "layerRules".
+func CheckDeps(g *graph.Graph, layerRules []graph.LayerRule) []error {
+	var errs []error
+	for _, cycle := range g.Cycles() {
+		var msg bytes.Buffer
+		fmt.Fprintf(&msg, "dependency cycle:")
+		for _, e := range cycle {
+			fmt.Fprintf(&msg, "\n\t%s", e)
+		}
+		errs = append(errs, errors.New(msg.String()))
+	}
+	for _, e := range g.Check(layerRules) {
+		errs = append(errs, fmt.Errorf("forbidden dependency: %s", e))
+	}
+	return errs
+}
+
+// processDir generates rules for the directory "d" with each language,
+// merges them with the existing build file, and emits the result. If "g" is
+// not nil, dependencies between targets are added to it. It returns whether
+// any rules were generated.
+func processDir(c *config.Config, langs []lang.Language, emit EmitFunc, d *packages.Dir, g *graph.Graph) bool {
+	var rs []*bzl.Rule
+	var loads []lang.LoadInfo
+	for _, l := range langs {
+		langRules, imports := l.GenerateRules(c, d)
+		for i, r := range langRules {
+			edges := l.Resolve(c, d.Rel, r, imports[i])
+			if g != nil {
+				for _, e := range edges {
+					g.Add(e)
+				}
+			}
+		}
+		rs = append(rs, langRules...)
+		loads = append(loads, l.Loads()...)
+	}
+	if len(rs) == 0 {
+		return false
+	}
+	loads = mapKinds(c, rs, loads)
+
+	genFile := &bzl.File{
+		Path: filepath.Join(d.Path, c.DefaultBuildFileName()),
+		Stmt: lang.GenerateLoads(loads, rs),
+	}
+	for _, r := range rs {
+		genFile.Stmt = append(genFile.Stmt, r.Call)
+	}
+	emitFile(c, emit, genFile, d.OldFile, loads)
+	return true
+}
+
+// mapKinds changes the kinds of rules in "rs" that are mapped to wrapper
+// kinds in c.KindMap. It returns "loads" with the .bzl files that define
+// the wrapper kinds added.
+func mapKinds(c *config.Config, rs []*bzl.Rule, loads []lang.LoadInfo) []lang.LoadInfo {
+	if len(c.KindMap) == 0 {
+		return loads
+	}
+	loads = append([]lang.LoadInfo{}, loads...)
+	for _, r := range rs {
+		mk, ok := c.KindMap[r.Kind()]
+		if !ok {
+			continue
+		}
+		r.Call.X = &bzl.LiteralExpr{Token: mk.KindName}
+		found := false
+		for i := range loads {
+			if loads[i].Name != mk.KindLoad {
+				continue
+			}
+			found = true
+			have := false
+			for _, k := range loads[i].Kinds {
+				have = have || k == mk.KindName
+			}
+			if !have {
+				// Copy Kinds, since it may be shared with the language.
+				kinds := append([]string{}, loads[i].Kinds...)
+				loads[i].Kinds = append(kinds, mk.KindName)
+			}
+			break
+		}
+		if !found {
+			loads = append(loads, lang.LoadInfo{Name: mk.KindLoad, Kinds: []string{mk.KindName}})
+		}
+	}
+	return loads
+}
+
+// emitFile merges "genFile" with "oldFile" (if it is not nil), formats the
+// result, and passes it to "emit". "loads" lists the files that rule kinds
+// may be loaded from.
+func emitFile(c *config.Config, emit EmitFunc, genFile, oldFile *bzl.File, loads []lang.LoadInfo) {
+	var record []byte
+	if c.ThreeWayMerge || c.WriteRecords {
+		// Format the generated file before merging, so it can be compared
+		// with records of previously generated files.
+		bzl.Rewrite(genFile, nil)
+		record = bzl.Format(genFile)
+	}
+
+	if oldFile == nil {
+		// No existing file, so no merge required.
+		bzl.Rewrite(genFile, nil) // have buildifier 'format' our rules.
+		if err := emit(c, genFile); err != nil {
+			log.Print(err)
+			return
+		}
+		writeRecord(c, genFile.Path, record)
+		return
+	}
+
+	// Existing file, so merge and replace the old one.
+	var baseFile *bzl.File
+	if c.ThreeWayMerge {
+		baseFile = readRecord(c, oldFile.Path)
+	}
+	opts := merger.Options{Kinds: make(map[string]string)}
+	for from, mk := range c.KindMap {
+		opts.Kinds[from] = mk.KindName
+	}
+	for _, l := range loads {
+		opts.Loads = append(opts.Loads, merger.Load{Name: l.Name, Kinds: l.Kinds})
+	}
+	mergedFile := merger.MergeWithBase(genFile, baseFile, oldFile, opts)
+	if mergedFile == nil {
+		// The existing file has a "# gazelle:ignore" comment.
+		return
+	}
+	bzl.Rewrite(mergedFile, nil) // have buildifier 'format' our rules.
+	if err := emit(c, mergedFile); err != nil {
+		log.Print(err)
+		return
+	}
+	writeRecord(c, mergedFile.Path, record)
+}
+
+// readRecord reads the record of the file Gazelle generated for the build
+// file at "buildPath". nil is returned if there is no record or it can't
+// be parsed.
+func readRecord(c *config.Config, buildPath string) *bzl.File {
+	path := c.RecordPath(buildPath)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print(err)
+		}
+		return nil
+	}
+	f, err := bzl.Parse(path, data)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	return f
+}
+
+// writeRecord writes "record", the content Gazelle generated for the build
+// file at "buildPath", if c.WriteRecords is set.
+func writeRecord(c *config.Config, buildPath string, record []byte) {
+	if !c.WriteRecords {
+		return
+	}
+	path := c.RecordPath(buildPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		log.Print(err)
+		return
+	}
+	if err := writeRecordDirBuildFile(c); err != nil {
+		log.Print(err)
+	}
+	if _, err := fileutil.WriteFile(path, record); err != nil {
+		log.Print(err)
+	}
+}
+
+// recordDirBuildFile is the content of the build file Gazelle writes in
+// config.RecordDir.
+const recordDirBuildFile = `# Gazelle keeps records of the build files it generated in this directory.
+# This file makes it a separate package, so globs in the root package
+# don't match the records.
+`
+
+// writeRecordDirBuildFile writes a build file in config.RecordDir if there
+// isn't one already. Without it, records would be matched by globs like
+// glob(["**"]) in the repository's root package.
+func writeRecordDirBuildFile(c *config.Config) error {
+	dir := filepath.Join(c.RepoRoot, config.RecordDir)
+	if _, err := os.Stat(dir); err != nil {
+		// Records are kept next to build files outside the repository.
+		return nil
+	}
+	for _, name := range c.ValidBuildFileNames {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return nil
+		}
+	}
+	path := filepath.Join(dir, c.DefaultBuildFileName())
+	_, err := fileutil.WriteFile(path, []byte(recordDirBuildFile))
+	return err
+}
+
+// ErrNoWorkspace is returned by SetDefaults when c.RepoRoot is not set and
+// there is no WORKSPACE file in the directory being processed or any of its
+// parents. CreateWorkspace may be used to create one.
+var ErrNoWorkspace = errors.New("-repo_root not specified, and WORKSPACE cannot be found")
+
+// errNoGoPrefix is returned by LoadGoPrefix when the root BUILD file has
+// no go_prefix rule.
+var errNoGoPrefix = errors.New("-go_prefix not set, and no go_prefix in root BUILD file")
+
+// SetDefaults fills in fields of c that were not set explicitly and checks
+// that the configuration is valid. c.Dirs defaults to the current
+// directory, and relative paths are made absolute. If c.RepoRoot is empty,
+// it is set to the directory containing the WORKSPACE file above c.Dirs.
+// If c.GoPrefix is empty, it is read from the go_prefix rule in the root
+// BUILD file. If there is no such rule, it is inferred with InferGoPrefix.
+//
+// ErrNoWorkspace is returned if c.RepoRoot is empty and no WORKSPACE file
+// can be found.
+func SetDefaults(c *config.Config) error {
+	var err error
+	if len(c.Dirs) == 0 {
+		c.Dirs = []string{"."}
+	}
+	for i := range c.Dirs {
+		c.Dirs[i], err = filepath.Abs(c.Dirs[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.RepoRoot == "" {
+		dir := c.Dirs[0]
+		if len(c.Dirs) > 1 {
+			if dir, err = filepath.Abs("."); err != nil {
+				return err
+			}
+		}
+		if c.RepoRoot, err = wspace.Find(dir); os.IsNotExist(err) {
+			return ErrNoWorkspace
+		} else if err != nil {
+			return fmt.Errorf("-repo_root not specified, and WORKSPACE cannot be found: %v", err)
+		}
+	} else if c.RepoRoot, err = filepath.Abs(c.RepoRoot); err != nil {
+		return err
+	}
+
+	for _, dir := range c.Dirs {
+		if !isDescendingDir(dir, c.RepoRoot) {
+			return fmt.Errorf("dir %q is not a subdirectory of repo root %q", dir, c.RepoRoot)
+		}
+	}
+
+	if len(c.ValidBuildFileNames) == 0 {
+		c.ValidBuildFileNames = config.DefaultValidBuildFileNames
+	}
+
+	if c.GenericTags == nil {
+		c.GenericTags = make(config.BuildTags)
+	}
+	if c.Platforms == nil {
+		c.Platforms = config.DefaultPlatformTags
+	}
+	c.PreprocessTags()
+
+	if c.GoPrefix == "" {
+		c.GoPrefix, err = LoadGoPrefix(c)
+		if err == os.ErrNotExist || err == errNoGoPrefix {
+			prefix, source, inferErr := InferGoPrefix(c)
+			if inferErr != nil {
+				return fmt.Errorf("-go_prefix not set, no go_prefix rule in root BUILD file, and %v", inferErr)
+			}
+			log.Printf("-go_prefix not set; using %q inferred from %s", prefix, source)
+			c.GoPrefix = prefix
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindBuildFile returns the path to the BUILD file in dir. The names in
+// c.ValidBuildFileNames are checked in order. os.ErrNotExist is returned
+// if there is no BUILD file.
+func FindBuildFile(c *config.Config, dir string) (string, error) {
+	for _, base := range c.ValidBuildFileNames {
+		p := filepath.Join(dir, base)
+		fi, err := os.Stat(p)
+		if err == nil {
+			if fi.Mode().IsRegular() {
+				return p, nil
+			}
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// LoadGoPrefix reads the argument of the go_prefix rule in the BUILD file
+// in c.RepoRoot.
+func LoadGoPrefix(c *config.Config) (string, error) {
+	p, err := FindBuildFile(c, c.RepoRoot)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	f, err := bzl.Parse(p, b)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range f.Stmt {
+		c, ok := s.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		l, ok := c.X.(*bzl.LiteralExpr)
+		if !ok {
+			continue
+		}
+		if l.Token != "go_prefix" {
+			continue
+		}
+		if len(c.List) != 1 {
+			return "", fmt.Errorf("found go_prefix(%v) with too many args", c.List)
+		}
+		v, ok := c.List[0].(*bzl.StringExpr)
+		if !ok {
+			return "", fmt.Errorf("found go_prefix(%v) which is not a string", c.List)
+		}
+		return v.Value, nil
+	}
+	return "", errNoGoPrefix
+}
+
+func isDescendingDir(dir, root string) bool {
+	if dir == root {
+		return true
+	}
+	return strings.HasPrefix(dir, fmt.Sprintf("%s%c", root, filepath.Separator))
+}
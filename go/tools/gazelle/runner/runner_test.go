@@ -0,0 +1,837 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/graph"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/lang"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+)
+
+func TestGenerate(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range []struct{ path, content string }{
+		{"WORKSPACE", ""},
+		{"BUILD", `go_prefix("example.com/repo")`},
+		{"lib/lib.go", "package lib"},
+		{"ignored/BUILD", "# gazelle:ignore"},
+		{"ignored/ignored.go", "package ignored"},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &config.Config{Dirs: []string{dir}}
+	if err := SetDefaults(c); err != nil {
+		t.Fatalf("SetDefaults failed with %v; want success", err)
+	}
+	if got, want := c.GoPrefix, "example.com/repo"; got != want {
+		t.Errorf("got prefix %q; want %q", got, want)
+	}
+	if got, want := c.RepoRoot, dir; got != want {
+		t.Errorf("got repo root %q; want %q", got, want)
+	}
+
+	files := Generate(c)
+	var got []string
+	for _, f := range files {
+		rel, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, filepath.ToSlash(rel))
+	}
+	sort.Strings(got)
+	want := []string{"BUILD", "lib/BUILD.bazel"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got files %q; want %q", got, want)
+	}
+	for _, f := range files {
+		if len(f.Rules("go_prefix")) == 0 && len(f.Rules("go_library")) == 0 {
+			t.Errorf("%s: no rules generated:\n%s", f.Path, bzl.Format(f))
+		}
+	}
+}
+
+func TestCheckDeps(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range []struct{ path, content string }{
+		{"WORKSPACE", ""},
+		{"a/a.go", `package a; import _ "example.com/repo/b"`},
+		{"b/b.go", `package b; import _ "example.com/repo/a"`},
+		{"lib/lib.go", `package lib; import _ "example.com/repo/cmd/tool"`},
+		{"cmd/tool/tool.go", "package tool"},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &config.Config{
+		Dirs:     []string{dir},
+		GoPrefix: "example.com/repo",
+	}
+	if err := SetDefaults(c); err != nil {
+		t.Fatalf("SetDefaults failed with %v; want success", err)
+	}
+	g := graph.New()
+	RunWithDeps(c, func(*config.Config, *bzl.File) error { return nil }, g)
+
+	var got []string
+	layerRules := []graph.LayerRule{{From: "//lib/...", To: "//cmd/..."}}
+	for _, err := range CheckDeps(g, layerRules) {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`dependency cycle:
+	//a:go_default_library imports "example.com/repo/b" in a/a.go (//b:go_default_library)
+	//b:go_default_library imports "example.com/repo/a" in b/b.go (//a:go_default_library)`,
+		`forbidden dependency: //lib:go_default_library imports "example.com/repo/cmd/tool" in lib/lib.go (//cmd/tool:go_default_library)`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestNamingConventions(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range []struct{ path, content string }{
+		{"WORKSPACE", ""},
+		{"BUILD", "# gazelle:naming_convention import\n"},
+		{"lib/foo/foo.go", "package foo"},
+		{"lib/foo/foo_test.go", "package foo"},
+		{"legacy/BUILD", "# gazelle:naming_convention default\n"},
+		{"legacy/bar/bar.go", "package bar"},
+		{"cmd/tool/main.go", `package main
+
+import (
+	_ "example.com/repo/legacy/bar"
+	_ "example.com/repo/lib/foo"
+)
+`},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &config.Config{
+		Dirs:     []string{filepath.Join(dir, "lib"), filepath.Join(dir, "cmd")},
+		RepoRoot: dir,
+		GoPrefix: "example.com/repo",
+	}
+	if err := SetDefaults(c); err != nil {
+		t.Fatalf("SetDefaults failed with %v; want success", err)
+	}
+	got := make(map[string]string)
+	for _, f := range Generate(c) {
+		rel, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[filepath.ToSlash(rel)] = string(bzl.Format(f))
+	}
+
+	want := map[string]string{
+		"lib/foo/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "foo",
+    srcs = ["foo.go"],
+    importpath = "example.com/repo/lib/foo",
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "foo_test",
+    srcs = ["foo_test.go"],
+    library = ":foo",
+)
+`,
+		"cmd/tool/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library")
+
+go_library(
+    name = "tool_lib",
+    srcs = ["main.go"],
+    importpath = "example.com/repo/cmd/tool",
+    visibility = ["//visibility:private"],
+    deps = [
+        "//legacy/bar:go_default_library",
+        "//lib/foo",
+    ],
+)
+
+go_binary(
+    name = "tool",
+    library = ":tool_lib",
+    visibility = ["//visibility:public"],
+)
+`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+func TestCombineTests(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range []struct{ path, content string }{
+		{"WORKSPACE", ""},
+		{"BUILD", "# gazelle:combine_tests true\n"},
+		{"lib/lib.go", "package lib"},
+		{"lib/export_test.go", `package lib; import _ "example.com/repo/util"`},
+		{"lib/lib_test.go", `package lib_test; import _ "example.com/repo/lib"`},
+		{"util/util.go", "package util"},
+		{"util/util_test.go", "package util_test"},
+		{"xonly/xonly_test.go", "package xonly_test"},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &config.Config{
+		Dirs:     []string{dir},
+		GoPrefix: "example.com/repo",
+	}
+	if err := SetDefaults(c); err != nil {
+		t.Fatalf("SetDefaults failed with %v; want success", err)
+	}
+	got := make(map[string]string)
+	for _, f := range Generate(c) {
+		rel, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rel == "BUILD" {
+			continue
+		}
+		got[filepath.ToSlash(rel)] = string(bzl.Format(f))
+	}
+
+	want := map[string]string{
+		"lib/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    srcs = ["export_test.go"],
+    library = ":go_default_library",
+    xtest_srcs = ["lib_test.go"],
+    deps = ["//util:go_default_library"],
+)
+`,
+		"util/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["util.go"],
+    visibility = ["//visibility:public"],
+)
+
+go_test(
+    name = "go_default_test",
+    library = ":go_default_library",
+    xtest_srcs = ["util_test.go"],
+)
+`,
+		"xonly/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_xtest",
+    srcs = ["xonly_test.go"],
+)
+`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+func TestTestAttributes(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range []struct{ path, content string }{
+		{"WORKSPACE", ""},
+		{"BUILD", "# gazelle:test_timeout long\n# gazelle:test_tags exclusive\n"},
+		{"slow/slow_test.go", `package slow
+
+import "testing"
+
+func TestSlow(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+}
+`},
+		{"integ/integ_test.go", "// +build integration\n\npackage integ\n"},
+		{"integ/integ.go", "// +build integration\n\npackage integ\n"},
+		{"mixed/unit_test.go", "// +build !integration\n\npackage mixed\n"},
+		{"mixed/int_test.go", "// +build integration\n\npackage mixed\n"},
+		{"sharded/BUILD", "# gazelle:test_size large\n# gazelle:test_shard_count 4\n"},
+		{"sharded/sharded_test.go", "package sharded"},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The default configuration is used, so sources that require the
+	// "integration" tag are only included in tests.
+	c := &config.Config{
+		Dirs:     []string{dir},
+		GoPrefix: "example.com/repo",
+	}
+	if err := SetDefaults(c); err != nil {
+		t.Fatalf("SetDefaults failed with %v; want success", err)
+	}
+	got := make(map[string]string)
+	for _, f := range Generate(c) {
+		rel, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rel == "BUILD" {
+			continue
+		}
+		got[filepath.ToSlash(rel)] = string(bzl.Format(f))
+	}
+
+	want := map[string]string{
+		"slow/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    size = "medium",
+    timeout = "long",
+    srcs = ["slow_test.go"],
+    tags = ["exclusive"],
+)
+`,
+		"integ/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    timeout = "long",
+    srcs = ["integ_test.go"],
+    tags = [
+        "exclusive",
+        "manual",
+    ],
+)
+`,
+		// Unit tests still run when a package also has integration tests.
+		"mixed/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    timeout = "long",
+    srcs = [
+        "int_test.go",
+        "unit_test.go",
+    ],
+    tags = ["exclusive"],
+)
+`,
+		"sharded/BUILD": `# gazelle:test_size large
+# gazelle:test_shard_count 4
+
+load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    size = "large",
+    timeout = "long",
+    srcs = ["sharded_test.go"],
+    shard_count = 4,
+    tags = ["exclusive"],
+)
+`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+func TestMocks(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range []struct{ path, content string }{
+		{"WORKSPACE", ""},
+		{"a/consumer_test.go", `package a; import _ "example.com/repo/lib/mocks"`},
+		{"lib/BUILD", "# gazelle:mock mocks Reader,Writer\n"},
+		{"lib/lib.go", "package lib"},
+		{"lib/lib_test.go", `package lib_test; import _ "example.com/repo/lib/mocks"`},
+		{"lib/sub/sub.go", "package sub"},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &config.Config{
+		Dirs:     []string{dir},
+		GoPrefix: "example.com/repo",
+	}
+	if err := SetDefaults(c); err != nil {
+		t.Fatalf("SetDefaults failed with %v; want success", err)
+	}
+	got := make(map[string]string)
+	for _, f := range Generate(c) {
+		rel, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rel == "BUILD.bazel" {
+			continue
+		}
+		got[filepath.ToSlash(rel)] = string(bzl.Format(f))
+	}
+
+	want := map[string]string{
+		"a/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_test")
+
+go_test(
+    name = "go_default_test",
+    srcs = ["consumer_test.go"],
+    deps = ["//lib:mocks"],
+)
+`,
+		"lib/BUILD": `# gazelle:mock mocks Reader,Writer
+
+load("@io_bazel_rules_go//go:def.bzl", "go_binary", "go_library", "go_test")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    visibility = ["//visibility:public"],
+)
+
+genrule(
+    name = "mocks_mockgen_prog",
+    outs = ["mocks_mockgen_prog.go"],
+    cmd = "$(location @com_github_golang_mock//mockgen) -prog_only example.com/repo/lib Reader,Writer > $@",
+    tools = ["@com_github_golang_mock//mockgen"],
+)
+
+go_binary(
+    name = "mocks_mockgen_bin",
+    srcs = ["mocks_mockgen_prog.go"],
+    visibility = ["//visibility:private"],
+    deps = [
+        ":go_default_library",
+        "@com_github_golang_mock//mockgen/model:go_default_library",
+    ],
+)
+
+genrule(
+    name = "mocks_mockgen",
+    outs = ["mocks_mockgen.go"],
+    cmd = "$(location @com_github_golang_mock//mockgen) -exec_only $(location :mocks_mockgen_bin) -package mocks example.com/repo/lib Reader,Writer > $@",
+    tools = [
+        ":mocks_mockgen_bin",
+        "@com_github_golang_mock//mockgen",
+    ],
+)
+
+go_library(
+    name = "mocks",
+    srcs = ["mocks_mockgen.go"],
+    importpath = "example.com/repo/lib/mocks",
+    visibility = ["//visibility:public"],
+    deps = [
+        ":go_default_library",
+        "@com_github_golang_mock//gomock:go_default_library",
+    ],
+)
+
+go_test(
+    name = "go_default_xtest",
+    srcs = ["lib_test.go"],
+    deps = [":mocks"],
+)
+`,
+		"lib/sub/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["sub.go"],
+    visibility = ["//visibility:public"],
+)
+`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
+
+func TestThreeWayMerge(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(rel, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func() *config.Config {
+		c := &config.Config{
+			Dirs:          []string{dir},
+			GoPrefix:      "example.com/repo",
+			ThreeWayMerge: true,
+			WriteRecords:  true,
+		}
+		if err := SetDefaults(c); err != nil {
+			t.Fatalf("SetDefaults failed with %v; want success", err)
+		}
+		Run(c, FixFile)
+		return c
+	}
+	write("WORKSPACE", "")
+	write("lib/lib.go", "package lib")
+	write("lib/other.go", "package lib")
+	c := run()
+
+	buildPath := filepath.Join(dir, "lib", "BUILD.bazel")
+	recordPath := c.RecordPath(buildPath)
+	if want := filepath.Join(c.RepoRoot, ".gazelle", "lib", "BUILD.bazel.gazelle"); recordPath != want {
+		t.Errorf("got record path %q; want %q", recordPath, want)
+	}
+	if _, err := os.Stat(recordPath); err != nil {
+		t.Fatalf("record was not written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(c.RepoRoot, ".gazelle", "BUILD.bazel")); err != nil {
+		t.Errorf("build file was not written in the record directory: %v", err)
+	}
+
+	// Edit the build file by hand, then add a file.
+	write("lib/BUILD.bazel", `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "extra.go",
+        "lib.go",
+    ],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+`)
+	write("lib/new.go", "package lib")
+	run()
+
+	got, err := ioutil.ReadFile(buildPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = [
+        "extra.go",
+        "lib.go",
+        "new.go",
+    ],
+    importpath = "example.com/repo/lib",
+    visibility = ["//visibility:public"],
+)
+`
+	if string(got) != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestNonRecursive(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range []struct{ path, content string }{
+		{"WORKSPACE", ""},
+		{"BUILD.bazel", "# gazelle:default_visibility //:__subpackages__\n"},
+		{"lib/lib.go", "package lib"},
+		{"lib/sub/sub.go", "package sub"},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &config.Config{
+		Dirs:         []string{filepath.Join(dir, "lib")},
+		GoPrefix:     "example.com/repo",
+		NonRecursive: true,
+	}
+	if err := SetDefaults(c); err != nil {
+		t.Fatalf("SetDefaults failed with %v; want success", err)
+	}
+	var got []string
+	Run(c, func(_ *config.Config, f *bzl.File) error {
+		got = append(got, f.Path)
+		if !strings.Contains(string(bzl.Format(f)), `"//:__subpackages__"`) {
+			t.Errorf("%s: directive in parent directory was not applied:\n%s", f.Path, bzl.Format(f))
+		}
+		return nil
+	})
+	want := []string{filepath.Join(dir, "lib", "BUILD.bazel")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %q; want %q", got, want)
+	}
+}
+
+func TestImportComments(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range []struct{ path, content string }{
+		{"WORKSPACE", ""},
+		{"lib/lib.go", `package lib // import "example.com/canonical"`},
+		{"cmd/main.go", `package main
+
+import _ "example.com/canonical"
+`},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &config.Config{
+		Dirs:     []string{dir},
+		GoPrefix: "example.com/repo",
+	}
+	if err := SetDefaults(c); err != nil {
+		t.Fatalf("SetDefaults failed with %v; want success", err)
+	}
+	got := make(map[string]string)
+	for _, f := range Generate(c) {
+		rel, _ := filepath.Rel(dir, f.Path)
+		got[filepath.ToSlash(rel)] = string(bzl.Format(f))
+	}
+	for rel, want := range map[string]string{
+		"lib/BUILD.bazel": `importpath = "example.com/canonical"`,
+		"cmd/BUILD.bazel": `deps = ["//lib:go_default_library"]`,
+	} {
+		if !strings.Contains(got[rel], want) {
+			t.Errorf("%s: got %s; want content containing %s", rel, got[rel], want)
+		}
+	}
+}
+
+// shLanguage is a minimal language which generates an sh_test rule for each
+// file ending with "_test.sh".
+type shLanguage struct{}
+
+func (shLanguage) Name() string { return "sh" }
+
+func (shLanguage) Loads() []lang.LoadInfo { return nil }
+
+func (shLanguage) Configure(c *config.Config, rel string, f *bzl.File) {
+	c.Exts["sh"] = rel
+}
+
+func (shLanguage) GenerateRules(c *config.Config, d *packages.Dir) ([]*bzl.Rule, []interface{}) {
+	if got := c.Exts["sh"]; got != d.Rel {
+		log.Panicf("in %q, got configuration for %q", d.Rel, got)
+	}
+	var rs []*bzl.Rule
+	var imports []interface{}
+	for _, f := range d.Files {
+		if !strings.HasSuffix(f, "_test.sh") {
+			continue
+		}
+		r := &bzl.Rule{Call: &bzl.CallExpr{X: &bzl.LiteralExpr{Token: "sh_test"}}}
+		r.SetAttr("name", &bzl.StringExpr{Value: strings.TrimSuffix(f, ".sh")})
+		r.SetAttr("srcs", &bzl.ListExpr{List: []bzl.Expr{&bzl.StringExpr{Value: f}}})
+		rs = append(rs, r)
+		imports = append(imports, "data.txt")
+	}
+	return rs, imports
+}
+
+func (shLanguage) Resolve(c *config.Config, rel string, r *bzl.Rule, imports interface{}) []graph.Edge {
+	r.SetAttr("data", &bzl.ListExpr{List: []bzl.Expr{&bzl.StringExpr{Value: imports.(string)}}})
+	return nil
+}
+
+func TestRunLanguages(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range []struct{ path, content string }{
+		{"lib/lib.go", "package lib"},
+		{"lib/lib_test.sh", ""},
+		{"scripts/run_test.sh", ""},
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &config.Config{
+		Dirs:     []string{filepath.Join(dir, "lib"), filepath.Join(dir, "scripts")},
+		RepoRoot: dir,
+		GoPrefix: "example.com/repo",
+	}
+	if err := SetDefaults(c); err != nil {
+		t.Fatalf("SetDefaults failed with %v; want success", err)
+	}
+
+	got := make(map[string]string)
+	langs := []lang.Language{rules.NewLanguage(), shLanguage{}}
+	run(c, langs, func(_ *config.Config, f *bzl.File) error {
+		rel, err := filepath.Rel(dir, f.Path)
+		if err != nil {
+			return err
+		}
+		got[filepath.ToSlash(rel)] = string(bzl.Format(f))
+		return nil
+	}, nil)
+
+	want := map[string]string{
+		"lib/BUILD.bazel": `load("@io_bazel_rules_go//go:def.bzl", "go_library")
+
+go_library(
+    name = "go_default_library",
+    srcs = ["lib.go"],
+    visibility = ["//visibility:public"],
+)
+
+sh_test(
+    name = "lib_test",
+    srcs = ["lib_test.sh"],
+    data = ["data.txt"],
+)
+`,
+		"scripts/BUILD.bazel": `sh_test(
+    name = "run_test",
+    srcs = ["run_test.sh"],
+    data = ["data.txt"],
+)
+`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v; want %#v", got, want)
+	}
+}
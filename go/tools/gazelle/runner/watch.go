@@ -0,0 +1,407 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/lang"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long Watch waits after a file changes for more
+// changes before it updates build files.
+const watchDebounce = 200 * time.Millisecond
+
+// watchExtensions is the set of extensions of source files that affect
+// generated rules.
+var watchExtensions = map[string]bool{
+	".go":    true,
+	".proto": true,
+	".c":     true,
+	".cc":    true,
+	".cpp":   true,
+	".cxx":   true,
+	".h":     true,
+	".hh":    true,
+	".hpp":   true,
+	".s":     true,
+	".S":     true,
+}
+
+// watcher reports changes to files in a set of directories.
+type watcher interface {
+	// add starts watching the directory "dir". Subdirectories are not
+	// watched unless they are added, too.
+	add(dir string) error
+
+	// changes returns a channel that receives the paths of files and
+	// directories that are created, changed, or deleted in watched
+	// directories. The path of a watched directory is sent if it is deleted.
+	// An empty string is sent if changes were lost, for example because
+	// too many happened at once.
+	changes() <-chan string
+
+	// close stops watching. The channel returned by changes is closed.
+	close() error
+}
+
+// fsWatcher is a watcher implemented with fsnotify.
+type fsWatcher struct {
+	w    *fsnotify.Watcher
+	ch   chan string
+	done chan struct{}
+}
+
+func newWatcher() (watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &fsWatcher{
+		w:    fw,
+		ch:   make(chan string, 100),
+		done: make(chan struct{}),
+	}
+	go w.run(fw.Events, fw.Errors)
+	return w, nil
+}
+
+func (w *fsWatcher) add(dir string) error {
+	return w.w.Add(dir)
+}
+
+func (w *fsWatcher) changes() <-chan string {
+	return w.ch
+}
+
+func (w *fsWatcher) close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	close(w.done)
+	return w.w.Close()
+}
+
+// run sends the paths of changed files from "events" to w.ch until either
+// channel is closed or the watcher is closed. If fsnotify reports that its
+// event queue overflowed, an empty string is sent. w.ch is closed when run
+// returns.
+func (w *fsWatcher) run(events <-chan fsnotify.Event, errs <-chan error) {
+	defer close(w.ch)
+	for {
+		var path string
+		select {
+		case <-w.done:
+			return
+
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if e.Op == fsnotify.Chmod {
+				continue
+			}
+			path = e.Name
+
+		case err, ok := <-errs:
+			if !ok {
+				return
+			}
+			if err != fsnotify.ErrEventOverflow {
+				log.Print(err)
+				continue
+			}
+			// Events were lost. The receiver must assume anything changed.
+			path = ""
+		}
+
+		select {
+		case w.ch <- path:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Watch runs Gazelle like Run, then watches c.Dirs and their subdirectories
+// for changes to source files. When sources change, build files are
+// updated for the directories that contain them. When a build file changes,
+// build files for rules that depend on it are updated, too, since imports
+// may resolve differently. Changes are batched: build files are updated
+// once no files have changed for a short time. Nested repositories and
+// import comments are found once, when Watch starts.
+//
+// Watch returns when "stop" is closed, or with an error if files can't be
+// watched.
+func Watch(c *config.Config, emit EmitFunc, stop <-chan struct{}) error {
+	return watch(c, lang.Languages(), emit, stop)
+}
+
+func watch(c *config.Config, langs []lang.Language, emit EmitFunc, stop <-chan struct{}) error {
+	w, err := newWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.close()
+
+	s := &watchState{
+		c:          c,
+		langs:      langs,
+		emit:       emit,
+		w:          w,
+		watched:    make(map[string]bool),
+		content:    make(map[string]string),
+		deps:       make(map[string][]string),
+		dependents: make(map[string]map[string]bool),
+	}
+	for _, dir := range c.Dirs {
+		s.addTree(dir, nil)
+	}
+	run(c, langs, s.record, nil)
+
+	pending := make(map[string]bool)
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+
+		case path, ok := <-w.changes():
+			if !ok {
+				return errors.New("stopped watching files")
+			}
+			if path == "" {
+				// Changes were lost. Update everything.
+				log.Print("too many changes at once; updating all build files")
+				for _, dir := range c.Dirs {
+					s.addTree(dir, pending)
+				}
+			} else {
+				s.affected(path, pending)
+			}
+			if len(pending) > 0 {
+				timer = time.After(watchDebounce)
+			}
+
+		case <-timer:
+			timer = nil
+			dirs := pending
+			pending = make(map[string]bool)
+			s.update(dirs)
+		}
+	}
+}
+
+// watchState holds information about the build files Watch has generated.
+type watchState struct {
+	c     *config.Config
+	langs []lang.Language
+	emit  EmitFunc
+	w     watcher
+
+	// watched is the set of absolute paths of watched directories.
+	watched map[string]bool
+
+	// content maps the slash-separated paths of directories to the content
+	// of the build files last emitted for them.
+	content map[string]string
+
+	// deps maps directories to the directories of the rules their rules
+	// depend on. dependents is the reverse.
+	deps       map[string][]string
+	dependents map[string]map[string]bool
+
+	// changed is the set of directories with build files that changed
+	// during an update.
+	changed map[string]bool
+}
+
+// addTree watches "dir" and its subdirectories, skipping excluded
+// directories and nested repositories. If "dirs" is not nil, the paths of
+// the directories are added to it.
+func (s *watchState) addTree(dir string, dirs map[string]bool) {
+	packages.WalkDirs(s.c, dir, nil, func(d *packages.Dir) bool {
+		if !s.watched[d.Path] {
+			if err := s.w.add(d.Path); err != nil {
+				log.Print(err)
+			} else {
+				s.watched[d.Path] = true
+			}
+		}
+		if dirs != nil {
+			dirs[d.Path] = true
+		}
+		return false
+	})
+}
+
+// affected adds to "dirs" the directories that need to be updated because
+// the file or directory at "path" changed.
+func (s *watchState) affected(path string, dirs map[string]bool) {
+	if s.watched[path] {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(s.watched, path)
+			dirs[path] = true
+		}
+		return
+	}
+	base := filepath.Base(path)
+	if strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") {
+		return
+	}
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		s.addTree(path, dirs)
+		return
+	}
+	if watchExtensions[filepath.Ext(base)] {
+		dirs[filepath.Dir(path)] = true
+	}
+}
+
+// update updates build files in "dirs", then in directories with rules
+// that depend on build files that changed.
+func (s *watchState) update(dirs map[string]bool) {
+	s.changed = make(map[string]bool)
+	for _, dir := range sortedKeys(dirs) {
+		s.visit(dir)
+	}
+
+	dependents := make(map[string]bool)
+	for rel := range s.changed {
+		for d := range s.dependents[rel] {
+			if dir := filepath.Join(s.c.RepoRoot, filepath.FromSlash(d)); !dirs[dir] {
+				dependents[dir] = true
+			}
+		}
+	}
+	for _, dir := range sortedKeys(dependents) {
+		s.visit(dir)
+	}
+}
+
+// visit generates and emits the build file for "dir".
+func (s *watchState) visit(dir string) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		// The directory was deleted. Rules that depended on it may change.
+		rel := s.rel(dir)
+		if _, ok := s.content[rel]; ok {
+			delete(s.content, rel)
+			s.setDeps(rel, nil)
+			s.changed[rel] = true
+		}
+		return
+	}
+	packages.VisitDir(s.c, dir, configureFunc(s.langs), func(d *packages.Dir) bool {
+		return processDir(d.Config, s.langs, s.record, d, nil)
+	})
+}
+
+// record is an EmitFunc that records the content and dependencies of
+// "f", then emits it.
+func (s *watchState) record(c *config.Config, f *bzl.File) error {
+	rel := s.rel(filepath.Dir(f.Path))
+	content := string(bzl.Format(f))
+	if s.content[rel] != content {
+		s.content[rel] = content
+		if s.changed != nil {
+			s.changed[rel] = true
+		}
+	}
+	s.setDeps(rel, fileDeps(rel, f))
+	return s.emit(c, f)
+}
+
+// setDeps records that rules in the directory "rel" depend on rules in
+// the directories "deps".
+func (s *watchState) setDeps(rel string, deps []string) {
+	for _, d := range s.deps[rel] {
+		delete(s.dependents[d], rel)
+	}
+	s.deps[rel] = deps
+	for _, d := range deps {
+		if s.dependents[d] == nil {
+			s.dependents[d] = make(map[string]bool)
+		}
+		s.dependents[d][rel] = true
+	}
+}
+
+func (s *watchState) rel(dir string) string {
+	rel, err := filepath.Rel(s.c.RepoRoot, dir)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// fileDeps returns the directories of labels in the deps attributes of
+// rules in "f", which is in the directory "rel". Labels in other
+// repositories and in "rel" itself are skipped.
+func fileDeps(rel string, f *bzl.File) []string {
+	seen := make(map[string]bool)
+	var deps []string
+	for _, s := range f.Stmt {
+		call, ok := s.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		attr := (&bzl.Rule{Call: call}).Attr("deps")
+		if attr == nil {
+			continue
+		}
+		bzl.Walk(attr, func(x bzl.Expr, stk []bzl.Expr) {
+			str, ok := x.(*bzl.StringExpr)
+			if !ok || !strings.HasPrefix(str.Value, "//") {
+				return
+			}
+			if len(stk) > 0 {
+				if kv, ok := stk[len(stk)-1].(*bzl.KeyValueExpr); ok && kv.Key == x {
+					return // select condition
+				}
+			}
+			pkg := strings.TrimPrefix(str.Value, "//")
+			if i := strings.Index(pkg, ":"); i >= 0 {
+				pkg = pkg[:i]
+			}
+			if pkg != rel && !seen[pkg] {
+				seen[pkg] = true
+				deps = append(deps, pkg)
+			}
+		})
+	}
+	return deps
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
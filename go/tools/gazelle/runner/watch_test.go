@@ -0,0 +1,171 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestWatch(t *testing.T) {
+	tmpdir := os.Getenv("TEST_TMPDIR")
+	dir, err := ioutil.TempDir(tmpdir, "")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(%q, %q) failed with %v; want success", tmpdir, "", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(rel, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// waitFor waits until the build file in "rel" contains "want".
+	waitFor := func(rel, want string) {
+		path := filepath.Join(dir, filepath.FromSlash(rel), "BUILD.bazel")
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			content, _ := ioutil.ReadFile(path)
+			if strings.Contains(string(content), want) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: got %s; want content containing %q", path, content, want)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	write("WORKSPACE", "")
+	write("lib/lib.go", "package lib")
+	c := &config.Config{
+		Dirs:     []string{dir},
+		GoPrefix: "example.com/repo",
+	}
+	if err := SetDefaults(c); err != nil {
+		t.Fatalf("SetDefaults failed with %v; want success", err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- Watch(c, FixFile, stop)
+	}()
+	waitFor("lib", `"lib.go"`)
+
+	// Files added to a watched directory are picked up.
+	write("lib/other.go", "package lib")
+	waitFor("lib", `"other.go"`)
+
+	// So are new directories.
+	write("cmd/main.go", `package main
+
+import _ "example.com/repo/lib"
+`)
+	waitFor("cmd", `"//lib:go_default_library"`)
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("Watch failed with %v; want success", err)
+	}
+}
+
+func TestWatcherOverflowAndClose(t *testing.T) {
+	events := make(chan fsnotify.Event)
+	errs := make(chan error)
+	w := &fsWatcher{
+		ch:   make(chan string, 100),
+		done: make(chan struct{}),
+	}
+	go w.run(events, errs)
+
+	events <- fsnotify.Event{Name: "a.go", Op: fsnotify.Write}
+	events <- fsnotify.Event{Name: "b.go", Op: fsnotify.Chmod}
+	errs <- fsnotify.ErrEventOverflow
+	close(events)
+
+	var got []string
+	for path := range w.ch {
+		got = append(got, path)
+	}
+	if want := []string{"a.go", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestWatcherClose(t *testing.T) {
+	w, err := newWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.close(); err != nil {
+		t.Errorf("close failed with %v; want success", err)
+	}
+	if err := w.close(); err != nil {
+		t.Errorf("second close failed with %v; want success", err)
+	}
+	select {
+	case _, ok := <-w.changes():
+		if ok {
+			t.Errorf("got a change after close; want the channel to be closed")
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("changes channel was not closed")
+	}
+}
+
+func TestFileDeps(t *testing.T) {
+	f, err := bzl.Parse("BUILD.bazel", []byte(`
+go_library(
+    name = "go_default_library",
+    deps = [
+        ":local",
+        "//a:go_default_library",
+        "//b",
+        "@ext//c:go_default_library",
+    ] + select({
+        "//conditions:default": ["//a:other"],
+        "@io_bazel_rules_go//go/platform:linux": ["//d:go_default_library"],
+    }),
+)
+
+go_test(
+    name = "go_default_test",
+    deps = ["//x:go_default_library"],
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fileDeps("x", f)
+	want := []string{"a", "b", "d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -10,7 +10,8 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package wspace provides functions to locate and modify a bazel WORKSPACE file.
+// Package wspace provides functions to locate, read, and modify bazel
+// WORKSPACE files.
 package wspace
 
 import (
@@ -18,15 +19,19 @@ import (
 	"path/filepath"
 )
 
-const workspaceFile = "WORKSPACE"
+// FileNames are the names of files that mark the root of a workspace, in
+// the order Bazel checks them. When both are present, WORKSPACE.bazel is
+// used.
+var FileNames = []string{"WORKSPACE.bazel", "WORKSPACE"}
 
-// Find searches from the given dir and up for the WORKSPACE file
-// returning the directory containing it, or an error if none found in the tree.
+// Find searches from the given dir and up for a WORKSPACE or
+// WORKSPACE.bazel file, returning the directory containing it, or an error
+// if none found in the tree.
 func Find(dir string) (string, error) {
 	if dir == "" || dir == "/" {
 		return "", os.ErrNotExist
 	}
-	_, err := os.Stat(filepath.Join(dir, workspaceFile))
+	_, err := FindFile(dir)
 	if err == nil {
 		return dir, nil
 	}
@@ -39,3 +44,34 @@ func Find(dir string) (string, error) {
 	}
 	return Find(parent)
 }
+
+// FindFile returns the path to the workspace file in "dir", which must be
+// the root of a workspace. The names in FileNames are checked in order.
+// os.ErrNotExist is returned if there is no workspace file.
+func FindFile(dir string) (string, error) {
+	for _, base := range FileNames {
+		p := filepath.Join(dir, base)
+		fi, err := os.Stat(p)
+		if err == nil {
+			if fi.Mode().IsRegular() {
+				return p, nil
+			}
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// IsFileName returns whether "base" is the name of a file that marks the
+// root of a workspace.
+func IsFileName(base string) bool {
+	for _, name := range FileNames {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
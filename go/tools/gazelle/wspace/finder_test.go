@@ -32,16 +32,25 @@ func TestFind(t *testing.T) {
 	if err := os.MkdirAll(filepath.Join(tmp, "base", "sub"), 0755); err != nil {
 		t.Fatal(err)
 	}
-	if err := ioutil.WriteFile(filepath.Join(tmp, "base", workspaceFile), nil, 0755); err != nil {
+	if err := ioutil.WriteFile(filepath.Join(tmp, "base", "WORKSPACE"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(tmp, "base", "sub", "bazel", "inner"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "base", "sub", "bazel", "WORKSPACE.bazel"), nil, 0755); err != nil {
 		t.Fatal(err)
 	}
 
 	tmpBase := filepath.Join(tmp, "base")
+	tmpBazel := filepath.Join(tmpBase, "sub", "bazel")
 
 	for _, tc := range []testCase{
 		{tmp, ""},
 		{tmpBase, tmpBase},
-		{filepath.Join(tmpBase, "sub"), tmpBase}} {
+		{filepath.Join(tmpBase, "sub"), tmpBase},
+		{filepath.Join(tmpBazel, "inner"), tmpBazel}} {
 
 		d, err := Find(tc.dir)
 		if err != nil {
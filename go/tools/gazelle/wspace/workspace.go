@@ -0,0 +1,170 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/fileutil"
+)
+
+// Workspace describes a Bazel workspace and holds its parsed WORKSPACE file.
+type Workspace struct {
+	// Root is the absolute path to the workspace root directory.
+	Root string
+
+	// Name is the workspace name declared with workspace(name = ...) in
+	// the WORKSPACE file. It is empty if no name is declared.
+	Name string
+
+	// ExternalDir is the directory where Bazel fetches external
+	// repositories for the workspace. It is found through the bazel-<dir>
+	// convenience symlink in Root and is empty if Bazel hasn't been run in
+	// the workspace.
+	ExternalDir string
+
+	// File is the parsed WORKSPACE or WORKSPACE.bazel file. File.Path is
+	// the path to the file.
+	File *bzl.File
+}
+
+// Load searches "dir" and its parents for a workspace file like Find, then
+// reads the workspace with Read.
+func Load(dir string) (*Workspace, error) {
+	root, err := Find(dir)
+	if err != nil {
+		return nil, err
+	}
+	return Read(root)
+}
+
+// Read reads the workspace rooted at "root". os.ErrNotExist is returned
+// if there is no workspace file in root.
+func Read(root string) (*Workspace, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	p, err := FindFile(root)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := bzl.Parse(p, data)
+	if err != nil {
+		return nil, err
+	}
+	w := &Workspace{
+		Root:        root,
+		File:        f,
+		ExternalDir: externalDir(root),
+	}
+	for _, r := range f.Rules("workspace") {
+		if name := r.AttrString("name"); name != "" {
+			w.Name = name
+			break
+		}
+	}
+	return w, nil
+}
+
+// externalDir returns the directory where Bazel stores external
+// repositories for the workspace rooted at "root", or "" if it can't be
+// found. The bazel-<dir> symlink points to <output_base>/execroot/<name>,
+// and external repositories are in <output_base>/external.
+func externalDir(root string) string {
+	execRoot, err := filepath.EvalSymlinks(filepath.Join(root, "bazel-"+filepath.Base(root)))
+	if err != nil {
+		return ""
+	}
+	for _, dir := range []string{
+		filepath.Join(filepath.Dir(filepath.Dir(execRoot)), "external"),
+		filepath.Join(execRoot, "external"),
+	} {
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+// Repositories returns the repository rules declared in the WORKSPACE
+// file, in order. Any top-level call with a name attribute, other than
+// workspace, is considered a repository rule.
+func (w *Workspace) Repositories() []*bzl.Rule {
+	var repos []*bzl.Rule
+	for _, r := range w.File.Rules("") {
+		if r.Kind() != "workspace" && r.Name() != "" {
+			repos = append(repos, r)
+		}
+	}
+	return repos
+}
+
+// Repository returns the repository rule with the given name, or nil if
+// there is none.
+func (w *Workspace) Repository(name string) *bzl.Rule {
+	for _, r := range w.Repositories() {
+		if r.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// AddRepository appends a repository rule of the given kind and name to
+// the WORKSPACE file and returns it. Attributes may be set on the returned
+// rule with SetAttr. An error is returned if a repository with the same
+// name is already declared.
+func (w *Workspace) AddRepository(kind, name string) (*bzl.Rule, error) {
+	if w.Repository(name) != nil {
+		return nil, fmt.Errorf("%s: repository %q is already declared", w.File.Path, name)
+	}
+	r := &bzl.Rule{Call: &bzl.CallExpr{X: &bzl.LiteralExpr{Token: kind}}}
+	r.SetAttr("name", &bzl.StringExpr{Value: name})
+	w.File.Stmt = append(w.File.Stmt, r.Call)
+	return r, nil
+}
+
+// RemoveRepository removes the repository rule with the given name from
+// the WORKSPACE file. It returns whether a rule was removed.
+func (w *Workspace) RemoveRepository(name string) bool {
+	for i, stmt := range w.File.Stmt {
+		call, ok := stmt.(*bzl.CallExpr)
+		if !ok {
+			continue
+		}
+		if r := (&bzl.Rule{Call: call}); r.Kind() != "workspace" && r.Name() == name {
+			w.File.Stmt = append(w.File.Stmt[:i], w.File.Stmt[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Save formats the WORKSPACE file and writes it back to File.Path. The
+// file is replaced atomically, so an interrupted write does not destroy it.
+func (w *Workspace) Save() error {
+	_, err := fileutil.WriteFile(w.File.Path, bzl.Format(w.File))
+	return err
+}
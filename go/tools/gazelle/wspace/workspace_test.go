@@ -0,0 +1,224 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+func TestRead(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	root := filepath.Join(tmp, "repo")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "WORKSPACE"), []byte(`workspace(name = "ignored")`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "WORKSPACE.bazel"), []byte(`workspace(name = "com_example_repo")`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wantExternal := ""
+	if runtime.GOOS != "windows" {
+		execRoot := filepath.Join(tmp, "output_base", "execroot", "com_example_repo")
+		wantExternal = filepath.Join(tmp, "output_base", "external")
+		for _, dir := range []string{execRoot, wantExternal} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink(execRoot, filepath.Join(root, "bazel-repo")); err != nil {
+			t.Fatal(err)
+		}
+		if wantExternal, err = filepath.EvalSymlinks(wantExternal); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := Load(filepath.Join(root, "sub"))
+	if err != nil {
+		t.Fatalf("Load failed with %v; want success", err)
+	}
+	if w.Root != root {
+		t.Errorf("got root %q; want %q", w.Root, root)
+	}
+	if want := filepath.Join(root, "WORKSPACE.bazel"); w.File.Path != want {
+		t.Errorf("got file %q; want %q", w.File.Path, want)
+	}
+	if want := "com_example_repo"; w.Name != want {
+		t.Errorf("got name %q; want %q", w.Name, want)
+	}
+	if w.ExternalDir != wantExternal {
+		t.Errorf("got external directory %q; want %q", w.ExternalDir, wantExternal)
+	}
+}
+
+func TestRepositoriesAllKinds(t *testing.T) {
+	f, err := bzl.Parse("WORKSPACE", []byte(`workspace(name = "ws")
+
+load("@io_bazel_rules_go//go:def.bzl", "go_repositories", "go_repository")
+load("//:deps.bzl", "my_deps")
+
+go_repositories()
+
+git_repository(
+    name = "git",
+    remote = "https://example.com/git.git",
+    commit = "123",
+)
+
+http_archive(
+    name = "http",
+    url = "https://example.com/http.zip",
+)
+
+new_go_repository(
+    name = "new_go",
+    importpath = "example.com/new_go",
+)
+
+local_repository(
+    name = "local",
+    path = "/tmp/local",
+)
+
+bind(
+    name = "bound",
+    actual = "@git//:lib",
+)
+
+my_deps(name = "macro")
+
+go_repository(
+    name = "go",
+    importpath = "example.com/go",
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Workspace{File: f}
+	var names []string
+	for _, r := range w.Repositories() {
+		names = append(names, r.Kind()+":"+r.Name())
+	}
+	want := []string{
+		"git_repository:git",
+		"http_archive:http",
+		"new_go_repository:new_go",
+		"local_repository:local",
+		"bind:bound",
+		"my_deps:macro",
+		"go_repository:go",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got repositories %q; want %q", names, want)
+	}
+}
+
+func TestEditRepositories(t *testing.T) {
+	tmp, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	p := filepath.Join(tmp, "WORKSPACE")
+	if err := ioutil.WriteFile(p, []byte(`workspace(name = "old")
+
+load("@io_bazel_rules_go//go:def.bzl", "go_repositories", "go_repository")
+
+go_repositories()
+
+go_repository(
+    name = "old",
+    commit = "123",
+    importpath = "example.com/old",
+)
+
+go_repository(
+    name = "keep",
+    importpath = "example.com/keep",
+)
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Read(tmp)
+	if err != nil {
+		t.Fatalf("Read failed with %v; want success", err)
+	}
+	var names []string
+	for _, r := range w.Repositories() {
+		names = append(names, r.Name())
+	}
+	if want := []string{"old", "keep"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got repositories %q; want %q", names, want)
+	}
+
+	if _, err := w.AddRepository("go_repository", "keep"); err == nil {
+		t.Errorf("AddRepository succeeded with a duplicate name; want error")
+	}
+	r, err := w.AddRepository("go_repository", "new")
+	if err != nil {
+		t.Fatalf("AddRepository failed with %v; want success", err)
+	}
+	r.SetAttr("importpath", &bzl.StringExpr{Value: "example.com/new"})
+	w.Repository("keep").SetAttr("commit", &bzl.StringExpr{Value: "456"})
+	if !w.RemoveRepository("old") {
+		t.Errorf("RemoveRepository(%q) = false; want true", "old")
+	}
+	if err := w.Save(); err != nil {
+		t.Fatalf("Save failed with %v; want success", err)
+	}
+
+	got, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `workspace(name = "old")
+
+load("@io_bazel_rules_go//go:def.bzl", "go_repositories", "go_repository")
+
+go_repositories()
+
+go_repository(
+    name = "keep",
+    importpath = "example.com/keep",
+    commit = "456",
+)
+
+go_repository(
+    name = "new",
+    importpath = "example.com/new",
+)
+`
+	if string(got) != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
@@ -19,11 +19,9 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 
 	bzl "github.com/bazelbuild/buildtools/build"
@@ -40,6 +38,14 @@ var (
 		"org_golang_google": "google.golang.org/",
 		"com_google_cloud":  "cloud.google.com/",
 	}
+
+	// updatableKinds is the set of repository rule kinds whose commit can be
+	// updated. Other rules with the same name are left alone.
+	updatableKinds = map[string]bool{
+		"go_repository":     true,
+		"new_go_repository": true,
+		"git_repository":    true,
+	}
 )
 
 func main() {
@@ -54,29 +60,35 @@ func run(args []string) error {
 	if err != nil {
 		return err
 	}
-	w, err := wspace.Find(cwd)
-	if err != nil {
-		return err
-	}
-	p := filepath.Join(w, "WORKSPACE")
-	b, err := ioutil.ReadFile(p)
-	if err != nil {
-		return err
-	}
-	f, err := bzl.Parse(p, b)
+	w, err := wspace.Load(cwd)
 	if err != nil {
 		return err
 	}
 	for _, arg := range args {
-		imp, err := findImport(arg)
+		name, importpath, err := nameAndImportpath(arg)
 		if err != nil {
 			return err
 		}
-		// TODO(pmbethe09): ignore or maybe update sha1 if already defined in workspace.
-		f.Stmt = append(f.Stmt, imp)
+		r := w.Repository(name)
+		if r != nil && !updatableKinds[r.Kind()] {
+			return fmt.Errorf("%s: repository %q is a %s; only go_repository, new_go_repository, and git_repository rules can be updated", w.File.Path, name, r.Kind())
+		}
+		commit, err := findCommit(r, importpath)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			if r, err = w.AddRepository("new_go_repository", name); err != nil {
+				return err
+			}
+			r.SetAttr("importpath", &bzl.StringExpr{Value: importpath})
+		}
+		// TODO(pmbethe09): allow ref to be provided, e.g. com_github_golang_glog:mybranch
+		r.DelAttr("tag")
+		r.SetAttr("commit", &bzl.StringExpr{Value: commit})
 	}
-	bzl.Rewrite(f, nil)
-	return ioutil.WriteFile(f.Path, bzl.Format(f), 0644)
+	bzl.Rewrite(w.File, nil)
+	return w.Save()
 }
 
 func nameAndImportpath(name string) (string, string, error) {
@@ -96,42 +108,37 @@ func nameAndImportpath(name string) (string, string, error) {
 	return name, strings.Join([]string{s[1] + "." + s[0], s[2], rest}, "/"), nil
 }
 
-func findImport(nameIn string) (bzl.Expr, error) {
-	name, importpath, err := nameAndImportpath(nameIn)
-	if err != nil {
-		return nil, err
+// findCommit returns the commit at the head of the git repository for the
+// existing repository rule "r". If r is nil or doesn't have a remote
+// attribute, the repository that serves r's importpath (or "importpath",
+// if r doesn't have one) is used, so forks and mirrors are updated from
+// the repository they were fetched from.
+func findCommit(r *bzl.Rule, importpath string) (string, error) {
+	if r != nil {
+		if remote := r.AttrString("remote"); remote != "" {
+			if v := r.AttrString("vcs"); v != "" && v != "git" {
+				return "", fmt.Errorf("%s: only git supported, not %q", r.Name(), v)
+			}
+			if *verbose {
+				log.Printf(remote)
+			}
+			return lsRemote(remote)
+		}
+		if p := r.AttrString("importpath"); p != "" {
+			importpath = p
+		}
 	}
 	if *verbose {
 		log.Printf(importpath)
 	}
-	r, err := vcs.RepoRootForImportPath(importpath, false)
+	root, err := vcs.RepoRootForImportPath(importpath, false)
 	if err != nil {
-		return nil, err
-	}
-	if r.VCS.Cmd != "git" {
-		return nil, fmt.Errorf("only git supported, not %q", r.VCS.Cmd)
+		return "", err
 	}
-	// TODO(pmbethe09): allow ref to be provided, e.g. com_github_golang_glog:mybranch
-	commit, err := lsRemote(r.Repo)
-	if err != nil {
-		return nil, err
-	}
-	return &bzl.CallExpr{
-		X: &bzl.LiteralExpr{Token: "new_go_repository"},
-		List: []bzl.Expr{
-			attr("name", name),
-			attr("importpath", importpath),
-			attr("commit", commit),
-		},
-	}, nil
-}
-
-func attr(key, val string) *bzl.BinaryExpr {
-	return &bzl.BinaryExpr{
-		X:  &bzl.LiteralExpr{Token: key},
-		Op: "=",
-		Y:  &bzl.StringExpr{Value: val},
+	if root.VCS.Cmd != "git" {
+		return "", fmt.Errorf("only git supported, not %q", root.VCS.Cmd)
 	}
+	return lsRemote(root.Repo)
 }
 
 func lsRemote(repo string) (string, error) {
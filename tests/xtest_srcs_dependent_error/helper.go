@@ -0,0 +1,5 @@
+package helper
+
+import "github.com/bazelbuild/rules_go/tests/xtest_srcs_dependent_error"
+
+func Double() int { return 2 * lib.Value() }
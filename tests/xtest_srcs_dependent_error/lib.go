@@ -0,0 +1,5 @@
+package lib
+
+var value = 1
+
+func Value() int { return value }
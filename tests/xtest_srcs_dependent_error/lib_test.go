@@ -0,0 +1,3 @@
+package lib
+
+func SetValue(v int) { value = v }
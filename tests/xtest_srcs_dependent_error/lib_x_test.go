@@ -0,0 +1,15 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/rules_go/tests/xtest_srcs_dependent_error"
+	"github.com/bazelbuild/rules_go/tests/xtest_srcs_dependent_error/helper"
+)
+
+func TestDouble(t *testing.T) {
+	lib.SetValue(2)
+	if got, want := helper.Double(), 4; got != want {
+		t.Errorf("Double() = %d; want %d", got, want)
+	}
+}